@@ -1,63 +1,125 @@
 package auth
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// googleIssuers are the values Google puts into the iss claim of ID tokens.
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
 type GoogleClaims struct {
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Name          string    `json:"name"`
+	Picture       string    `json:"picture"`
+	GoogleID      string    `json:"sub"`
+	ExpiresAt     time.Time `json:"-"`
+}
+
+// idTokenClaims is the wire format of a Google ID token payload.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
 	Name          string `json:"name"`
 	Picture       string `json:"picture"`
-	GoogleID      string `json:"sub"`
 }
 
-type GoogleUserInfo struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	Picture       string `json:"picture"`
+type TokenValidatorConfig struct {
+	ClientID            string
+	AllowedExtensionIDs []string
+	// JWKSURL defaults to GoogleJWKSURL.
+	JWKSURL    string
+	HTTPClient *http.Client
+	// Leeway tolerates clock skew when checking exp, iat and nbf.
+	Leeway time.Duration
 }
 
 type TokenValidator struct {
 	clientID            string
 	allowedExtensionIDs []string
+	jwks                *JWKS
+	parser              *jwt.Parser
 }
 
-func NewTokenValidator(clientID string, allowedExtensionIDs []string) *TokenValidator {
+func NewTokenValidator(cfg TokenValidatorConfig) *TokenValidator {
+	jwksURL := cfg.JWKSURL
+	if jwksURL == "" {
+		jwksURL = GoogleJWKSURL
+	}
+
+	allowed := make([]string, 0, len(cfg.AllowedExtensionIDs))
+	for _, id := range cfg.AllowedExtensionIDs {
+		if id != "" {
+			allowed = append(allowed, id)
+		}
+	}
+
 	return &TokenValidator{
-		clientID:            clientID,
-		allowedExtensionIDs: allowedExtensionIDs,
+		clientID:            cfg.ClientID,
+		allowedExtensionIDs: allowed,
+		jwks:                NewJWKS(jwksURL, cfg.HTTPClient),
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+			jwt.WithLeeway(cfg.Leeway),
+		),
 	}
 }
 
+// ValidateGoogleToken verifies a Google-issued ID token offline: the RS256
+// signature is checked against the cached JWKS and iss, exp and aud are
+// validated against the configured client and extension IDs.
 func (v *TokenValidator) ValidateGoogleToken(token string) (*GoogleClaims, error) {
-	// Call the same endpoint as the frontend
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token)
+	var claims idTokenClaims
+	_, err := v.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("token header has no kid")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return v.jwks.Key(ctx, kid)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to validate token: %w", err)
+		return nil, fmt.Errorf("invalid token: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid token: status %d", resp.StatusCode)
+	if !slices.Contains(googleIssuers, claims.Issuer) {
+		return nil, fmt.Errorf("invalid token: unexpected issuer %q", claims.Issuer)
 	}
-
-	var userInfo GoogleUserInfo
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return nil, fmt.Errorf("failed to decode user info: %w", err)
+	if !v.audienceAllowed(claims.Audience) {
+		return nil, fmt.Errorf("invalid token: unexpected audience %v", claims.Audience)
 	}
-
-	claims := &GoogleClaims{
-		Email:         userInfo.Email,
-		EmailVerified: userInfo.VerifiedEmail,
-		Name:          userInfo.Name,
-		Picture:       userInfo.Picture,
-		GoogleID:      userInfo.ID,
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing subject")
 	}
 
-	return claims, nil
+	return &GoogleClaims{
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+		GoogleID:      claims.Subject,
+		ExpiresAt:     claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (v *TokenValidator) audienceAllowed(audience jwt.ClaimStrings) bool {
+	for _, aud := range audience {
+		if aud == "" {
+			continue
+		}
+		if aud == v.clientID || slices.Contains(v.allowedExtensionIDs, aud) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "client.apps.googleusercontent.com"
+	testExtensionID = "extension.apps.googleusercontent.com"
+)
+
+func newTestValidator(t *testing.T, server *keyServer) *TokenValidator {
+	t.Helper()
+	return NewTokenValidator(TokenValidatorConfig{
+		ClientID:            testClientID,
+		AllowedExtensionIDs: []string{testExtensionID},
+		JWKSURL:             server.URL,
+	})
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testClientID,
+		"sub":            "1234567890",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestValidateGoogleToken(t *testing.T) {
+	key := generateKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": key})
+	validator := newTestValidator(t, server)
+
+	claims := validClaims()
+	got, err := validator.ValidateGoogleToken(signToken(t, key, "k1", claims))
+	if err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	if got.GoogleID != "1234567890" || got.Email != "jane@example.com" || !got.EmailVerified {
+		t.Errorf("unexpected claims %+v", got)
+	}
+	if want := time.Unix(claims["exp"].(int64), 0); !got.ExpiresAt.Equal(want) {
+		t.Errorf("expected expiry %s, got %s", want, got.ExpiresAt)
+	}
+}
+
+func TestValidateGoogleTokenAcceptsExtensionAudience(t *testing.T) {
+	key := generateKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": key})
+	validator := newTestValidator(t, server)
+
+	claims := validClaims()
+	claims["aud"] = []string{"other", testExtensionID}
+	claims["iss"] = "accounts.google.com"
+	if _, err := validator.ValidateGoogleToken(signToken(t, key, "k1", claims)); err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+}
+
+func TestValidateGoogleTokenRejectsInvalidTokens(t *testing.T) {
+	key := generateKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": key})
+	validator := newTestValidator(t, server)
+
+	with := func(name string, value any) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := map[string]string{
+		"wrong issuer":   signToken(t, key, "k1", with("iss", "https://evil.example.com")),
+		"wrong audience": signToken(t, key, "k1", with("aud", "someone-else")),
+		"empty audience": signToken(t, key, "k1", with("aud", "")),
+		"expired":        signToken(t, key, "k1", with("exp", time.Now().Add(-time.Minute).Unix())),
+		"missing expiry": signToken(t, key, "k1", with("exp", nil)),
+		"issued later":   signToken(t, key, "k1", with("iat", time.Now().Add(time.Hour).Unix())),
+		"no subject":     signToken(t, key, "k1", with("sub", nil)),
+		"no kid":         signToken(t, key, "", validClaims()),
+		"unknown kid":    signToken(t, key, "k2", validClaims()),
+		"foreign key":    signToken(t, generateKey(t), "k1", validClaims()),
+	}
+
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	tests["hs256"] = hs256
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	tests["alg none"] = none
+
+	valid := signToken(t, key, "k1", validClaims())
+	parts := strings.Split(valid, ".")
+	tampered := validClaims()
+	tampered["sub"] = "someone-else"
+	tests["tampered payload"] = parts[0] + "." + strings.Split(signToken(t, key, "k1", tampered), ".")[1] + "." + parts[2]
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := validator.ValidateGoogleToken(token); err == nil {
+				t.Error("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestValidateGoogleTokenFollowsKeyRotation(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": oldKey})
+	validator := newTestValidator(t, server)
+	validator.jwks.minRefreshWait = 0
+
+	if _, err := validator.ValidateGoogleToken(signToken(t, oldKey, "k1", validClaims())); err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+
+	server.setKeys(map[string]*rsa.PrivateKey{"k1": oldKey, "k2": newKey})
+	if _, err := validator.ValidateGoogleToken(signToken(t, newKey, "k2", validClaims())); err != nil {
+		t.Fatalf("expected a token signed with the rotated key to be valid, got %v", err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Errorf("expected the key set to be refetched once, got %d fetches", n)
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devs-group/driplet/api/repositories"
+)
+
+const (
+	DefaultTokenCacheSize = 10000
+	DefaultTokenCacheTTL  = 30 * time.Second
+)
+
+// CachedIdentity is what RequireAuth needs to authenticate a request without
+// validating the token or hitting the database again.
+type CachedIdentity struct {
+	Claims *GoogleClaims
+	User   *repositories.User
+}
+
+type tokenCacheEntry struct {
+	key       string
+	identity  CachedIdentity
+	expiresAt time.Time
+}
+
+type settingsCacheEntry struct {
+	settings  *repositories.UserSettings
+	expiresAt time.Time
+}
+
+type TokenCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// TokenCache is a bounded LRU cache of validated tokens and the users they
+// resolved to. Entries are keyed by a SHA-256 of the token so raw bearer
+// tokens are never held in memory longer than the request. They expire after
+// the TTL, or with the token if it expires earlier: InvalidateUser only
+// reaches the local process, so the TTL bounds how long changes made by the
+// scheduler or other instances, such as credits, roles or a disabled account,
+// stay unnoticed.
+type TokenCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// byUser indexes cache keys by user id for InvalidateUser.
+	byUser map[string]map[string]struct{}
+	// settings are only kept for users with cached tokens, so they are
+	// bounded by the cache size as well.
+	settings map[string]settingsCacheEntry
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewTokenCache(maxEntries int, ttl time.Duration) *TokenCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultTokenCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenCacheTTL
+	}
+	return &TokenCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+		byUser:     map[string]map[string]struct{}{},
+		settings:   map[string]settingsCacheEntry{},
+	}
+}
+
+// Get returns the identity cached for the token. The returned user is a copy
+// so callers may modify it freely.
+func (c *TokenCache) Get(token string) (*CachedIdentity, bool) {
+	key := hashToken(token)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := el.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+
+	user := *entry.identity.User
+	return &CachedIdentity{Claims: entry.identity.Claims, User: &user}, true
+}
+
+// Set caches the identity for the TTL, but no longer than the token is valid.
+func (c *TokenCache) Set(token string, identity CachedIdentity) {
+	now := time.Now()
+	if identity.Claims == nil || identity.User == nil || !now.Before(identity.Claims.ExpiresAt) {
+		return
+	}
+	expiresAt := now.Add(c.ttl)
+	if identity.Claims.ExpiresAt.Before(expiresAt) {
+		expiresAt = identity.Claims.ExpiresAt
+	}
+	key := hashToken(token)
+	user := *identity.User
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+
+	entry := &tokenCacheEntry{
+		key:       key,
+		identity:  CachedIdentity{Claims: identity.Claims, User: &user},
+		expiresAt: expiresAt,
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.byUser[user.ID] == nil {
+		c.byUser[user.ID] = map[string]struct{}{}
+	}
+	c.byUser[user.ID][key] = struct{}{}
+
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// GetSettings returns the settings cached for the user. The returned settings
+// are a copy.
+func (c *TokenCache) GetSettings(userID string) (*repositories.UserSettings, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.settings[userID]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.settings, userID)
+		return nil, false
+	}
+	settings := *entry.settings
+	return &settings, true
+}
+
+// SetSettings caches the user's settings for the TTL. They are only cached
+// while the user has a cached token and are dropped together with it.
+func (c *TokenCache) SetSettings(userID string, settings *repositories.UserSettings) {
+	copied := *settings
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.byUser[userID]; !ok {
+		return
+	}
+	c.settings[userID] = settingsCacheEntry{
+		settings:  &copied,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+// InvalidateUser drops every cached token and the settings of the user. It
+// must be called whenever a user row or the user's settings change so that
+// the next request on this instance sees fresh data.
+func (c *TokenCache) InvalidateUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byUser[userID] {
+		if el, ok := c.entries[key]; ok {
+			c.removeElement(el)
+		}
+	}
+	delete(c.byUser, userID)
+	delete(c.settings, userID)
+}
+
+func (c *TokenCache) Stats() TokenCacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return TokenCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+func (c *TokenCache) removeElement(el *list.Element) {
+	entry := c.lru.Remove(el).(*tokenCacheEntry)
+	delete(c.entries, entry.key)
+
+	userID := entry.identity.User.ID
+	if keys, ok := c.byUser[userID]; ok {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.byUser, userID)
+			delete(c.settings, userID)
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GoogleJWKSURL is the endpoint serving the keys Google signs ID tokens with.
+	GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+	defaultJWKSCacheTTL       = time.Hour
+	defaultJWKSMinRefreshWait = time.Minute
+)
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// JWKS fetches and caches the RSA keys of a JSON Web Key Set. Keys are
+// refreshed when the cache expires (honouring Cache-Control max-age) or when
+// a token references a key id that is not cached yet, which is how key
+// rotation shows up.
+type JWKS struct {
+	url        string
+	httpClient *http.Client
+
+	// minRefreshWait rate-limits refreshes triggered by unknown key ids so
+	// that tokens with garbage kids can't be used to hammer the key server.
+	minRefreshWait time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	expiresAt   time.Time
+	lastFetched time.Time
+
+	refreshMu sync.Mutex
+}
+
+func NewJWKS(url string, httpClient *http.Client) *JWKS {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWKS{
+		url:            url,
+		httpClient:     httpClient,
+		minRefreshWait: defaultJWKSMinRefreshWait,
+		keys:           map[string]*rsa.PublicKey{},
+	}
+}
+
+// Key returns the public key with the given key id, fetching the key set if
+// it is stale or doesn't contain the key yet.
+func (j *JWKS) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	key, fresh := j.cached(kid)
+	if key != nil && fresh {
+		return key, nil
+	}
+
+	if err := j.refresh(ctx, key == nil); err != nil {
+		if key != nil {
+			// A stale key is still better than failing every request while
+			// the key server is unreachable.
+			slog.Warn("unable to refresh jwks, using stale key", "kid", kid, "err", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	key, _ = j.cached(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (j *JWKS) cached(kid string) (*rsa.PublicKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.keys[kid], time.Now().Before(j.expiresAt)
+}
+
+func (j *JWKS) refresh(ctx context.Context, unknownKid bool) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	j.mu.RLock()
+	expiresAt, lastFetched := j.expiresAt, j.lastFetched
+	j.mu.RUnlock()
+
+	// Another caller may have refreshed while we were waiting for the lock.
+	now := time.Now()
+	if now.Before(expiresAt) && (!unknownKid || now.Sub(lastFetched) < j.minRefreshWait) {
+		return nil
+	}
+
+	keys, ttl, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.lastFetched = now
+	j.expiresAt = now.Add(ttl)
+	j.mu.Unlock()
+
+	slog.Info("jwks has been refreshed", "url", j.url, "keys", len(keys), "ttl", ttl)
+	return nil
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]*rsa.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build jwks request: %w", err)
+	}
+	resp, err := j.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch jwks: status %d", resp.StatusCode)
+	}
+
+	var set jsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := parseRSAPublicKey(k.N, k.E)
+		if err != nil {
+			slog.Warn("skipping invalid jwk", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, 0, fmt.Errorf("jwks at %s contains no usable RSA keys", j.url)
+	}
+
+	return keys, cacheTTL(resp.Header.Get("Cache-Control")), nil
+}
+
+func parseRSAPublicKey(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(eBytes)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("exponent too large")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// cacheTTL extracts max-age from a Cache-Control header value.
+func cacheTTL(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			break
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultJWKSCacheTTL
+}
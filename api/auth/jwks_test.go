@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// keyServer serves a JSON Web Key Set that tests can rotate.
+type keyServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	keys   map[string]*rsa.PublicKey
+	status int
+
+	fetches atomic.Int32
+}
+
+func newKeyServer(t *testing.T, keys map[string]*rsa.PrivateKey) *keyServer {
+	t.Helper()
+	s := &keyServer{status: http.StatusOK}
+	s.setKeys(keys)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		set := jsonWebKeySet{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *keyServer) setKeys(keys map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = map[string]*rsa.PublicKey{}
+	for kid, key := range keys {
+		s.keys[kid] = &key.PublicKey
+	}
+}
+
+func (s *keyServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestJWKSCachesKeys(t *testing.T) {
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": generateKey(t)})
+	jwks := NewJWKS(server.URL, nil)
+
+	for range 3 {
+		if _, err := jwks.Key(context.Background(), "k1"); err != nil {
+			t.Fatalf("expected key k1, got %v", err)
+		}
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Errorf("expected 1 fetch, got %d", n)
+	}
+}
+
+func TestJWKSRefreshesOnRotation(t *testing.T) {
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": generateKey(t)})
+	jwks := NewJWKS(server.URL, nil)
+	jwks.minRefreshWait = 0
+
+	if _, err := jwks.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("expected key k1, got %v", err)
+	}
+
+	server.setKeys(map[string]*rsa.PrivateKey{"k2": generateKey(t)})
+	if _, err := jwks.Key(context.Background(), "k2"); err != nil {
+		t.Fatalf("expected rotated key k2, got %v", err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestJWKSRateLimitsUnknownKeyIDs(t *testing.T) {
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": generateKey(t)})
+	jwks := NewJWKS(server.URL, nil)
+
+	if _, err := jwks.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("expected key k1, got %v", err)
+	}
+	for range 3 {
+		if _, err := jwks.Key(context.Background(), "unknown"); err == nil {
+			t.Fatal("expected an error for an unknown key id")
+		}
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Errorf("expected unknown key ids not to refetch within the wait, got %d fetches", n)
+	}
+}
+
+func TestJWKSUsesStaleKeyWhileServerFails(t *testing.T) {
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": generateKey(t)})
+	jwks := NewJWKS(server.URL, nil)
+
+	if _, err := jwks.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("expected key k1, got %v", err)
+	}
+
+	server.setStatus(http.StatusInternalServerError)
+	jwks.mu.Lock()
+	jwks.expiresAt = time.Now().Add(-time.Second)
+	jwks.mu.Unlock()
+
+	if _, err := jwks.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("expected stale key k1, got %v", err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Errorf("expected the expired set to be refetched, got %d fetches", n)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := map[string]time.Duration{
+		"public, max-age=19845, must-revalidate": 19845 * time.Second,
+		"max-age=0":                              defaultJWKSCacheTTL,
+		"no-cache":                               defaultJWKSCacheTTL,
+		"":                                       defaultJWKSCacheTTL,
+	}
+	for header, want := range tests {
+		if got := cacheTTL(header); got != want {
+			t.Errorf("cacheTTL(%q) = %s, want %s", header, got, want)
+		}
+	}
+}
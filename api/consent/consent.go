@@ -0,0 +1,126 @@
+package consent
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/pkg/events"
+	"github.com/go-faster/errors"
+)
+
+// Categories of data a user can consent to.
+const (
+	// CategoryBrowsing covers page loads and navigations.
+	CategoryBrowsing = "browsing"
+	// CategoryEngagement covers exits, hidden tabs and the time spent on pages.
+	CategoryEngagement = "engagement"
+	// CategoryPageContent covers the links and images found on pages.
+	CategoryPageContent = "page_content"
+	// CategoryForms covers the structure of forms found on pages.
+	CategoryForms = "forms"
+)
+
+var Categories = []string{CategoryBrowsing, CategoryEngagement, CategoryPageContent, CategoryForms}
+
+// MaxExcludedDomains limits the domains a user can opt out of.
+const MaxExcludedDomains = 500
+
+// ErrConsentRequired rejects events of users that haven't agreed to the
+// current consent version. The other errors drop single events.
+var (
+	ErrConsentRequired      = errors.New("consent to the current version is required")
+	ErrPaused               = errors.New("collection is paused")
+	ErrDomainExcluded       = errors.New("domain is excluded")
+	ErrCategoryNotConsented = errors.New("category is not consented")
+)
+
+// HasConsent reports whether the user agreed to the current consent version.
+func HasConsent(settings *repositories.UserSettings, currentVersion int) bool {
+	return settings.ConsentVersion > 0 && settings.ConsentVersion >= currentVersion
+}
+
+// Check reports whether events of the user may be collected at all. Unless
+// consent is required, users that never saved settings are collected from as
+// before settings existed, since clients that don't ask for consent can't
+// record it. Users that saved settings always need the current version.
+func Check(settings *repositories.UserSettings, currentVersion int, required bool) error {
+	if HasConsent(settings, currentVersion) || (!required && !settings.IsSaved()) {
+		return nil
+	}
+	return ErrConsentRequired
+}
+
+// Apply checks the event against the user's settings and removes the parts
+// of it the user didn't consent to. Users let through by Check without
+// settings keep every category.
+func Apply(settings *repositories.UserSettings, currentVersion int, required bool, e *events.PageEvent, now time.Time) error {
+	if err := Check(settings, currentVersion, required); err != nil {
+		return err
+	}
+	if !settings.IsSaved() {
+		return nil
+	}
+	if settings.PauseUntil.Valid && now.Before(settings.PauseUntil.Time) {
+		return ErrPaused
+	}
+	if excluded(settings.ExcludedDomains, e.Website) || excluded(settings.ExcludedDomains, host(e.URL)) {
+		return ErrDomainExcluded
+	}
+
+	category := CategoryBrowsing
+	if e.Event == events.TypeExit || e.Event == events.TypeVisibilityHidden {
+		category = CategoryEngagement
+	}
+	if !slices.Contains(settings.Categories, category) {
+		return ErrCategoryNotConsented
+	}
+
+	if !slices.Contains(settings.Categories, CategoryEngagement) {
+		e.TimeSpentSeconds = 0
+	}
+	if !slices.Contains(settings.Categories, CategoryPageContent) {
+		e.Links = nil
+		e.Images = nil
+	}
+	if !slices.Contains(settings.Categories, CategoryForms) {
+		e.Forms = nil
+	}
+	return nil
+}
+
+// NormalizeDomain lowercases the domain and removes schemes, paths and
+// leading dots, so that "https://www.Example.com/" becomes "www.example.com".
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if h := host(domain); h != "" {
+		domain = h
+	}
+	if i := strings.IndexAny(domain, "/:"); i >= 0 {
+		domain = domain[:i]
+	}
+	return strings.Trim(domain, ".")
+}
+
+func excluded(domains []string, h string) bool {
+	h = strings.Trim(strings.ToLower(h), ".")
+	if h == "" {
+		return false
+	}
+	for _, d := range domains {
+		if h == d || strings.HasSuffix(h, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func host(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -2,12 +2,22 @@ package di
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"sync"
 
 	"github.com/devs-group/driplet/api/auth"
-	"github.com/devs-group/driplet/api/config"
+	"github.com/devs-group/driplet/api/export"
+	"github.com/devs-group/driplet/api/health"
+	"github.com/devs-group/driplet/api/metrics"
+	"github.com/devs-group/driplet/api/payouts"
+	"github.com/devs-group/driplet/api/ratelimit"
 	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/api/scrub"
+	"github.com/devs-group/driplet/pkg/config"
 	"github.com/devs-group/driplet/pkg/db"
+	"github.com/devs-group/driplet/pkg/events"
 	"github.com/devs-group/driplet/pkg/pubsub"
 	"github.com/devs-group/godi"
 	"github.com/jmoiron/sqlx"
@@ -15,29 +25,110 @@ import (
 
 var Container = godi.New()
 
-func Init() {
+// created holds the clients the container has created, so that Close only
+// closes those instead of resolving, and thereby connecting, the others.
+var created struct {
+	sync.Mutex
+	db           *sqlx.DB
+	pubsubClient *pubsub.Client
+}
+
+func Init(cfg *config.Config) {
+	// Register config
+	godi.Register(Container, func() *config.Config {
+		return cfg
+	}, godi.Singleton)
+
 	// Register database
 	godi.Register(Container, func() *sqlx.DB {
-		database, err := db.Connect(db.DefaultConfig())
+		database, err := db.Connect(cfg.Database)
 		if err != nil {
 			log.Fatal(err)
 		}
+		metrics.RegisterDB(database.SQLX.DB)
+		created.Lock()
+		created.db = database.SQLX
+		created.Unlock()
 		return database.SQLX
 	}, godi.Singleton)
 
 	// Register pubsub
 	godi.Register(Container, func() *pubsub.Client {
 		ctx := context.Background()
-		client, err := pubsub.Connect(ctx, pubsub.DefaultConfig())
+		client, err := pubsub.Connect(ctx, cfg.PubSub)
 		if err != nil {
 			log.Fatal(err)
 		}
+		created.Lock()
+		created.pubsubClient = client
+		created.Unlock()
 		return client
 	}, godi.Singleton)
 
+	// Register readiness checker
+	godi.Register(Container, func() *health.Checker {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		pubsubClient, _ := godi.Resolve[*pubsub.Client](Container)
+		return health.NewChecker(cfg.API.HealthCheckTimeout, cfg.API.HealthCheckCacheTTL,
+			health.Check{Name: "postgres", Run: db.PingContext},
+			health.Check{Name: "pubsub", Run: func(ctx context.Context) error {
+				return pubsubClient.CheckTopic(ctx, events.Topic)
+			}},
+		)
+	}, godi.Singleton)
+
 	// Register token validator
 	godi.Register(Container, func() *auth.TokenValidator {
-		return auth.NewTokenValidator(config.GOOGLE_CLIENT_ID, config.ALLOWED_EXTENSION_CLIENT_IDS)
+		return auth.NewTokenValidator(auth.TokenValidatorConfig{
+			ClientID:            cfg.API.GoogleClientID,
+			AllowedExtensionIDs: cfg.API.AllowedExtensionClientIDs,
+			JWKSURL:             cfg.API.GoogleJWKSURL,
+		})
+	}, godi.Singleton)
+
+	// Register token cache
+	godi.Register(Container, func() *auth.TokenCache {
+		tokenCache := auth.NewTokenCache(cfg.API.TokenCacheSize, cfg.API.TokenCacheTTL)
+		metrics.RegisterTokenCache(tokenCache)
+		return tokenCache
+	}, godi.Singleton)
+
+	// Register event scrubber
+	godi.Register(Container, func() *scrub.Scrubber {
+		rules := scrub.DefaultRules()
+		if cfg.API.ScrubRulesFile != "" {
+			var err error
+			if rules, err = scrub.LoadRules(cfg.API.ScrubRulesFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		scrubber, err := scrub.NewScrubber(rules, cfg.API.ScrubHashSalt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return scrubber
+	}, godi.Singleton)
+
+	// Register rate limiter
+	godi.Register(Container, func() *ratelimit.Limiter {
+		rules := ratelimit.DefaultRules()
+		if cfg.API.RateLimitsFile != "" {
+			var err error
+			if rules, err = ratelimit.LoadRules(cfg.API.RateLimitsFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		var store ratelimit.Store
+		switch cfg.API.RateLimitStore {
+		case "", "memory":
+			store = ratelimit.NewMemoryStore()
+		case "postgres":
+			db, _ := godi.Resolve[*sqlx.DB](Container)
+			store = ratelimit.NewPostgresStore(db)
+		default:
+			log.Fatalf("unknown rate limit store %q", cfg.API.RateLimitStore)
+		}
+		return ratelimit.NewLimiter(store, rules)
 	}, godi.Singleton)
 
 	// Register user repository
@@ -45,4 +136,85 @@ func Init() {
 		db, _ := godi.Resolve[*sqlx.DB](Container)
 		return &repositories.UsersRepository{DB: db}
 	}, godi.Singleton)
+
+	// Register credit transactions repository
+	godi.Register(Container, func() *repositories.CreditTransactionsRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.CreditTransactionsRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register wallet challenges repository
+	godi.Register(Container, func() *repositories.WalletChallengesRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.WalletChallengesRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register admin repository
+	godi.Register(Container, func() *repositories.AdminRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.AdminRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register payouts repository
+	godi.Register(Container, func() *repositories.PayoutsRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.PayoutsRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register payouts service
+	godi.Register(Container, func() *payouts.Service {
+		payoutsRepository, _ := godi.Resolve[*repositories.PayoutsRepository](Container)
+		var transferer payouts.Transferer
+		switch cfg.API.PayoutTransferer {
+		case "fake":
+			transferer = payouts.FakeTransferer{}
+		case "":
+		default:
+			log.Fatalf("unknown payout transferer %q", cfg.API.PayoutTransferer)
+		}
+		return payouts.NewService(payoutsRepository, transferer, cfg.API.PayoutMinAmount)
+	}, godi.Singleton)
+
+	// Register user settings repository
+	godi.Register(Container, func() *repositories.UserSettingsRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.UserSettingsRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register events repository
+	godi.Register(Container, func() *repositories.EventsRepository {
+		db, _ := godi.Resolve[*sqlx.DB](Container)
+		return &repositories.EventsRepository{DB: db}
+	}, godi.Singleton)
+
+	// Register exporter
+	godi.Register(Container, func() *export.Exporter {
+		userSettingsRepository, _ := godi.Resolve[*repositories.UserSettingsRepository](Container)
+		creditTransactionsRepository, _ := godi.Resolve[*repositories.CreditTransactionsRepository](Container)
+		payoutsRepository, _ := godi.Resolve[*repositories.PayoutsRepository](Container)
+		eventsRepository, _ := godi.Resolve[*repositories.EventsRepository](Container)
+		return export.NewExporter(userSettingsRepository, creditTransactionsRepository, payoutsRepository, eventsRepository)
+	}, godi.Singleton)
+}
+
+// Close flushes the pubsub publishers and closes the pubsub client, then the
+// database, as far as they have been created. It must only be called once
+// nothing uses them anymore.
+func Close() error {
+	created.Lock()
+	defer created.Unlock()
+
+	var errs []error
+	if created.pubsubClient != nil {
+		slog.Info("flushing pubsub publishers")
+		created.pubsubClient.Flush()
+		errs = append(errs, created.pubsubClient.Close())
+		created.pubsubClient = nil
+	}
+	if created.db != nil {
+		slog.Info("closing database connection")
+		errs = append(errs, created.db.Close())
+		created.db = nil
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// pageSize is the number of rows loaded per query for paginated sections.
+const pageSize = 100
+
+// Exporter builds the archive of everything stored about a user.
+type Exporter struct {
+	userSettingsRepository       *repositories.UserSettingsRepository
+	creditTransactionsRepository *repositories.CreditTransactionsRepository
+	payoutsRepository            *repositories.PayoutsRepository
+	eventsRepository             *repositories.EventsRepository
+}
+
+func NewExporter(
+	userSettingsRepository *repositories.UserSettingsRepository,
+	creditTransactionsRepository *repositories.CreditTransactionsRepository,
+	payoutsRepository *repositories.PayoutsRepository,
+	eventsRepository *repositories.EventsRepository,
+) *Exporter {
+	return &Exporter{
+		userSettingsRepository:       userSettingsRepository,
+		creditTransactionsRepository: creditTransactionsRepository,
+		payoutsRepository:            payoutsRepository,
+		eventsRepository:             eventsRepository,
+	}
+}
+
+type profile struct {
+	ID                  string     `json:"id"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	Credits             int        `json:"credits"`
+	PublicKey           string     `json:"public_key,omitempty"`
+	PublicKeyVerifiedAt *time.Time `json:"public_key_verified_at,omitempty"`
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
+	PurgeAfter          *time.Time `json:"purge_after,omitempty"`
+	CreatedAt           string     `json:"created_at"`
+	UpdatedAt           string     `json:"updated_at"`
+}
+
+type settings struct {
+	ConsentVersion  int        `json:"consent_version"`
+	ConsentedAt     *time.Time `json:"consented_at,omitempty"`
+	Categories      []string   `json:"categories"`
+	ExcludedDomains []string   `json:"excluded_domains"`
+	PauseUntil      *time.Time `json:"pause_until,omitempty"`
+}
+
+type event struct {
+	ID         string         `json:"id"`
+	EventType  string         `json:"event_type"`
+	Website    string         `json:"website,omitempty"`
+	URL        string         `json:"url,omitempty"`
+	Payload    types.JSONText `json:"payload"`
+	OccurredAt *time.Time     `json:"occurred_at,omitempty"`
+	ReceivedAt time.Time      `json:"received_at"`
+	// ConsentVersion is the consent the event has been collected under.
+	ConsentVersion *int32 `json:"consent_version,omitempty"`
+}
+
+// WriteArchive writes a zip archive with the user's profile, settings,
+// credits history and payouts as JSON, and the stored events as NDJSON.
+func (e *Exporter) WriteArchive(ctx context.Context, w io.Writer, user *repositories.User) error {
+	archive := zip.NewWriter(w)
+
+	if err := writeJSON(archive, "profile.json", newProfile(user)); err != nil {
+		return err
+	}
+	if err := e.writeSettings(ctx, archive, user.ID); err != nil {
+		return err
+	}
+	if err := e.writeCreditsHistory(ctx, archive, user.ID); err != nil {
+		return err
+	}
+	if err := e.writePayouts(ctx, archive, user.ID); err != nil {
+		return err
+	}
+	if err := e.writeEvents(ctx, archive, user.ID); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (e *Exporter) writeSettings(ctx context.Context, archive *zip.Writer, userID string) error {
+	s, err := e.userSettingsRepository.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	record := settings{
+		ConsentVersion:  s.ConsentVersion,
+		Categories:      s.Categories,
+		ExcludedDomains: s.ExcludedDomains,
+	}
+	if s.ConsentedAt.Valid {
+		record.ConsentedAt = &s.ConsentedAt.Time
+	}
+	if s.PauseUntil.Valid {
+		record.PauseUntil = &s.PauseUntil.Time
+	}
+	return writeJSON(archive, "settings.json", record)
+}
+
+func (e *Exporter) writeCreditsHistory(ctx context.Context, archive *zip.Writer, userID string) error {
+	all := []credits.Transaction{}
+	for offset := 0; ; offset += pageSize {
+		items, _, err := e.creditTransactionsRepository.ListByUser(ctx, userID, pageSize, offset)
+		if err != nil {
+			return err
+		}
+		all = append(all, items...)
+		if len(items) < pageSize {
+			break
+		}
+	}
+	return writeJSON(archive, "credits_history.json", all)
+}
+
+func (e *Exporter) writePayouts(ctx context.Context, archive *zip.Writer, userID string) error {
+	all := []repositories.Payout{}
+	for offset := 0; ; offset += pageSize {
+		items, err := e.payoutsRepository.ListByUser(ctx, userID, pageSize, offset)
+		if err != nil {
+			return err
+		}
+		all = append(all, items...)
+		if len(items) < pageSize {
+			break
+		}
+	}
+	return writeJSON(archive, "payouts.json", all)
+}
+
+func (e *Exporter) writeEvents(ctx context.Context, archive *zip.Writer, userID string) error {
+	f, err := archive.Create("events.ndjson")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	return e.eventsRepository.EachByUser(ctx, userID, func(ev *repositories.Event) error {
+		record := event{
+			ID:         ev.ID,
+			EventType:  ev.EventType,
+			Website:    ev.Website.String,
+			URL:        ev.URL.String,
+			Payload:    ev.Payload,
+			ReceivedAt: ev.ReceivedAt,
+		}
+		if ev.OccurredAt.Valid {
+			record.OccurredAt = &ev.OccurredAt.Time
+		}
+		if ev.ConsentVersion.Valid {
+			record.ConsentVersion = &ev.ConsentVersion.Int32
+		}
+		return enc.Encode(record)
+	})
+}
+
+func newProfile(u *repositories.User) profile {
+	p := profile{
+		ID:        u.ID,
+		Email:     u.Email,
+		Role:      u.Role,
+		Credits:   u.Credits,
+		PublicKey: u.PublicKey.String,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+	if u.PublicKeyVerifiedAt.Valid {
+		p.PublicKeyVerifiedAt = &u.PublicKeyVerifiedAt.Time
+	}
+	if u.DeletionRequestedAt.Valid {
+		p.DeletionRequestedAt = &u.DeletionRequestedAt.Time
+	}
+	if u.PurgeAfter.Valid {
+		p.PurgeAfter = &u.PurgeAfter.Time
+	}
+	return p
+}
+
+func writeJSON(archive *zip.Writer, name string, v any) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
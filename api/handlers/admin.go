@@ -0,0 +1,530 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/devs-group/driplet/api/auth"
+	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/middlewares"
+	"github.com/devs-group/driplet/api/payouts"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/godi"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type AdminHandler struct {
+	adminRepository   *repositories.AdminRepository
+	usersRepository   *repositories.UsersRepository
+	eventsRepository  *repositories.EventsRepository
+	payoutsRepository *repositories.PayoutsRepository
+	payoutsService    *payouts.Service
+	tokenCache        *auth.TokenCache
+}
+
+func NewAdminHandler() (*AdminHandler, error) {
+	adminRepository, err := godi.Resolve[*repositories.AdminRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve admin repository")
+	}
+	usersRepository, err := godi.Resolve[*repositories.UsersRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve users repository")
+	}
+	eventsRepository, err := godi.Resolve[*repositories.EventsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve events repository")
+	}
+	payoutsRepository, err := godi.Resolve[*repositories.PayoutsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve payouts repository")
+	}
+	payoutsService, err := godi.Resolve[*payouts.Service](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve payouts service")
+	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve token cache")
+	}
+	return &AdminHandler{
+		adminRepository:   adminRepository,
+		usersRepository:   usersRepository,
+		eventsRepository:  eventsRepository,
+		payoutsRepository: payoutsRepository,
+		payoutsService:    payoutsService,
+		tokenCache:        tokenCache,
+	}, nil
+}
+
+type AdminUserResponse struct {
+	ID                  string     `json:"id"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	Credits             int        `json:"credits"`
+	PublicKey           string     `json:"public_key"`
+	PublicKeyVerified   bool       `json:"public_key_verified"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	RateLimitViolations int        `json:"rate_limit_violations"`
+	// RateLimitFlaggedAt is set once the user hit the rate limits repeatedly.
+	RateLimitFlaggedAt *time.Time `json:"rate_limit_flagged_at"`
+	CreatedAt          string     `json:"created_at"`
+}
+
+func newAdminUserResponse(u *repositories.User) *AdminUserResponse {
+	res := &AdminUserResponse{
+		ID:                  u.ID,
+		Email:               u.Email,
+		Role:                u.Role,
+		Credits:             u.Credits,
+		PublicKey:           u.PublicKey.String,
+		PublicKeyVerified:   u.HasVerifiedPublicKey(),
+		RateLimitViolations: u.RateLimitViolations,
+		CreatedAt:           u.CreatedAt,
+	}
+	if u.DisabledAt.Valid {
+		res.DisabledAt = &u.DisabledAt.Time
+	}
+	if u.RateLimitFlaggedAt.Valid {
+		res.RateLimitFlaggedAt = &u.RateLimitFlaggedAt.Time
+	}
+	return res
+}
+
+type AdminEventResponse struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id"`
+	EventType  string         `json:"event_type"`
+	Website    string         `json:"website"`
+	URL        string         `json:"url"`
+	Payload    types.JSONText `json:"payload"`
+	OccurredAt *time.Time     `json:"occurred_at"`
+	ReceivedAt time.Time      `json:"received_at"`
+}
+
+// audit records an admin action that doesn't change data. Actions that do
+// change data are recorded by the repository in the same transaction.
+func (h *AdminHandler) audit(c *fiber.Ctx, action, targetUserID string, details map[string]any) error {
+	entry, err := h.auditEntry(c, action, targetUserID, details)
+	if err != nil {
+		return err
+	}
+	if err := h.adminRepository.Record(c.UserContext(), entry); err != nil {
+		middlewares.Logger(c).Error("unable to record admin action", "action", action, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return nil
+}
+
+func (h *AdminHandler) auditEntry(c *fiber.Ctx, action, targetUserID string, details map[string]any) (*repositories.AuditLogEntry, error) {
+	admin, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return nil, fiber.ErrUnauthorized
+	}
+	entry, err := repositories.NewAuditLogEntry(admin.ID, action, targetUserID, details)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to build audit log entry", "action", action, "err", err)
+		return nil, fiber.ErrInternalServerError
+	}
+	return entry, nil
+}
+
+// idParam returns the id route parameter, answering 404 for anything that
+// isn't a UUID.
+func idParam(c *fiber.Ctx) (string, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return "", fiber.ErrNotFound
+	}
+	return id.String(), nil
+}
+
+// GET_Users lists users, optionally filtered by the q query parameter, which
+// matches parts of the email or a full user id.
+func (h *AdminHandler) GET_Users(c *fiber.Ctx) error {
+	q := c.Query("q")
+	limit, offset := pagination(c)
+	if err := h.audit(c, repositories.AuditActionListUsers, "", map[string]any{"q": q}); err != nil {
+		return err
+	}
+
+	users, total, err := h.usersRepository.Search(c.UserContext(), q, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to search users", "err", err)
+		return fiber.ErrInternalServerError
+	}
+	items := make([]*AdminUserResponse, 0, len(users))
+	for i := range users {
+		items = append(items, newAdminUserResponse(&users[i]))
+	}
+	return c.JSON(fiber.Map{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *AdminHandler) GET_User(c *fiber.Ctx) error {
+	userID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.usersRepository.FindByID(c.UserContext(), userID)
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		middlewares.Logger(c).Error("unable to find user", "user_id", userID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	if err := h.audit(c, repositories.AuditActionViewUser, user.ID, nil); err != nil {
+		return err
+	}
+	return c.JSON(newAdminUserResponse(user))
+}
+
+// POST_AdjustCredits adds a positive or negative amount to the user's credits.
+// A reason is required and goes to the audit log.
+func (h *AdminHandler) POST_AdjustCredits(c *fiber.Ctx) error {
+	userID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	payload := struct {
+		Amount int    `json:"amount"`
+		Reason string `json:"reason"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
+		return fiber.ErrBadRequest
+	}
+	if payload.Amount == 0 || payload.Reason == "" {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "amount must not be zero and reason is required",
+		})
+	}
+
+	entry, err := h.auditEntry(c, repositories.AuditActionAdjustCredits, userID, map[string]any{
+		"amount": payload.Amount,
+		"reason": payload.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	transaction, err := h.adminRepository.AdjustCredits(c.UserContext(), entry, payload.Amount)
+	switch {
+	case errors.Is(err, repositories.ErrUserNotFound):
+		return fiber.ErrNotFound
+	case errors.Is(err, repositories.ErrInsufficientCredits):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		middlewares.Logger(c).Error("unable to adjust credits", "user_id", userID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(userID)
+
+	return c.Status(fiber.StatusCreated).JSON(transaction)
+}
+
+func (h *AdminHandler) POST_DisableUser(c *fiber.Ctx) error {
+	return h.setDisabled(c, true)
+}
+
+func (h *AdminHandler) POST_EnableUser(c *fiber.Ctx) error {
+	return h.setDisabled(c, false)
+}
+
+func (h *AdminHandler) setDisabled(c *fiber.Ctx, disabled bool) error {
+	userID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	payload := struct {
+		Reason string `json:"reason"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			middlewares.Logger(c).Error("unable to parse request body", "err", err)
+			return fiber.ErrBadRequest
+		}
+	}
+
+	action := repositories.AuditActionEnableUser
+	if disabled {
+		action = repositories.AuditActionDisableUser
+	}
+	entry, err := h.auditEntry(c, action, userID, map[string]any{"reason": payload.Reason})
+	if err != nil {
+		return err
+	}
+	user, err := h.adminRepository.SetDisabled(c.UserContext(), entry, disabled)
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		middlewares.Logger(c).Error("unable to update user", "user_id", userID, "disabled", disabled, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(userID)
+
+	return c.JSON(newAdminUserResponse(user))
+}
+
+// GET_Events lists the most recently received events, optionally filtered by
+// the user_id query parameter.
+func (h *AdminHandler) GET_Events(c *fiber.Ctx) error {
+	userID := c.Query("user_id")
+	limit, offset := pagination(c)
+	if err := h.audit(c, repositories.AuditActionViewEvents, "", map[string]any{"user_id": userID}); err != nil {
+		return err
+	}
+
+	events, err := h.eventsRepository.ListRecent(c.UserContext(), userID, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list events", "user_id", userID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	items := make([]*AdminEventResponse, 0, len(events))
+	for _, e := range events {
+		item := &AdminEventResponse{
+			ID:         e.ID,
+			UserID:     e.UserID.String,
+			EventType:  e.EventType,
+			Website:    e.Website.String,
+			URL:        e.URL.String,
+			Payload:    e.Payload,
+			ReceivedAt: e.ReceivedAt,
+		}
+		if e.OccurredAt.Valid {
+			item.OccurredAt = &e.OccurredAt.Time
+		}
+		items = append(items, item)
+	}
+	return c.JSON(fiber.Map{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *AdminHandler) GET_Payouts(c *fiber.Ctx) error {
+	status := c.Query("status", repositories.PayoutStatusPending)
+	limit, offset := pagination(c)
+	if err := h.audit(c, repositories.AuditActionListPayouts, "", map[string]any{"status": status}); err != nil {
+		return err
+	}
+
+	items, err := h.payoutsRepository.ListByStatus(c.UserContext(), status, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list payouts", "status", status, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *AdminHandler) POST_SettlePayout(c *fiber.Ctx) error {
+	payoutID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	audit, err := h.payoutAudit(c, repositories.AuditActionSettlePayout, "")
+	if err != nil {
+		return err
+	}
+	payout, err := h.payoutsService.Settle(c.UserContext(), audit, payoutID)
+	return h.payoutResult(c, audit.Action, payoutID, payout, err)
+}
+
+func (h *AdminHandler) POST_RejectPayout(c *fiber.Ctx) error {
+	payoutID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	payload := struct {
+		Reason string `json:"reason"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			middlewares.Logger(c).Error("unable to parse request body", "err", err)
+			return fiber.ErrBadRequest
+		}
+	}
+	audit, err := h.payoutAudit(c, repositories.AuditActionRejectPayout, payload.Reason)
+	if err != nil {
+		return err
+	}
+	payout, err := h.payoutsService.Reject(c.UserContext(), audit, payoutID)
+	return h.payoutResult(c, audit.Action, payoutID, payout, err)
+}
+
+// POST_ResolvePayout settles an approved payout whose transfer outcome is
+// unknown. Admins check on-chain whether the tokens arrived and send either
+// sent with the transaction signature, or failed to refund the credits.
+func (h *AdminHandler) POST_ResolvePayout(c *fiber.Ctx) error {
+	payoutID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	payload := struct {
+		Outcome     string `json:"outcome"`
+		TxSignature string `json:"tx_signature"`
+		Reason      string `json:"reason"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
+		return fiber.ErrBadRequest
+	}
+	if payload.Outcome != repositories.PayoutStatusSent && payload.Outcome != repositories.PayoutStatusFailed {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "outcome must be sent or failed",
+		})
+	}
+	audit, err := h.payoutAudit(c, repositories.AuditActionResolvePayout, payload.Reason)
+	if err != nil {
+		return err
+	}
+	sent := payload.Outcome == repositories.PayoutStatusSent
+	payout, err := h.payoutsService.Resolve(c.UserContext(), audit, payoutID, sent, payload.TxSignature)
+	if errors.Is(err, payouts.ErrSignatureRequired) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return h.payoutResult(c, audit.Action, payoutID, payout, err)
+}
+
+// payoutAudit identifies the admin on whose behalf the payouts service
+// changes payouts. The service audits every change in its transactions.
+func (h *AdminHandler) payoutAudit(c *fiber.Ctx, action, reason string) (*repositories.PayoutAudit, error) {
+	admin, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return nil, fiber.ErrUnauthorized
+	}
+	return &repositories.PayoutAudit{AdminID: admin.ID, Action: action, Reason: reason}, nil
+}
+
+// payoutResult writes the response of a settled, rejected or resolved payout.
+func (h *AdminHandler) payoutResult(c *fiber.Ctx, action, payoutID string, payout *repositories.Payout, err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrPayoutNotFound):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "payout not found or not in the expected status",
+		})
+	case errors.Is(err, payouts.ErrTransfersDisabled):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		middlewares.Logger(c).Error("unable to update payout", "payout_id", payoutID, "action", action, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(payout.UserID)
+	return c.JSON(payout)
+}
+
+// GET_PointsReviews lists points withheld by the points job, by default those
+// still awaiting review.
+func (h *AdminHandler) GET_PointsReviews(c *fiber.Ctx) error {
+	status := c.Query("status", repositories.PointsReviewPending)
+	limit, offset := pagination(c)
+	if err := h.audit(c, repositories.AuditActionListPointsReviews, "", map[string]any{"status": status}); err != nil {
+		return err
+	}
+
+	items, err := h.adminRepository.ListPointsReviews(c.UserContext(), status, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list points reviews", "status", status, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// POST_ReleasePoints credits withheld points to the user.
+func (h *AdminHandler) POST_ReleasePoints(c *fiber.Ctx) error {
+	return h.reviewPoints(c, true)
+}
+
+// POST_RejectPoints keeps withheld points from being credited.
+func (h *AdminHandler) POST_RejectPoints(c *fiber.Ctx) error {
+	return h.reviewPoints(c, false)
+}
+
+func (h *AdminHandler) reviewPoints(c *fiber.Ctx, release bool) error {
+	ledgerID, err := idParam(c)
+	if err != nil {
+		return err
+	}
+	payload := struct {
+		Reason string `json:"reason"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			middlewares.Logger(c).Error("unable to parse request body", "err", err)
+			return fiber.ErrBadRequest
+		}
+	}
+
+	action := repositories.AuditActionRejectPoints
+	if release {
+		action = repositories.AuditActionReleasePoints
+	}
+	entry, err := h.auditEntry(c, action, "", map[string]any{
+		"ledger_id": ledgerID,
+		"reason":    payload.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	ledgerEntry, err := h.adminRepository.ReviewPoints(c.UserContext(), entry, ledgerID, release)
+	switch {
+	case errors.Is(err, repositories.ErrPointsReviewNotFound):
+		return fiber.ErrNotFound
+	case errors.Is(err, repositories.ErrPointsReviewNotPending):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		middlewares.Logger(c).Error("unable to review points", "ledger_id", ledgerID, "release", release, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(ledgerEntry.UserID)
+
+	return c.JSON(ledgerEntry)
+}
+
+// GET_AuditLog lists admin actions, optionally filtered by the user_id query
+// parameter.
+func (h *AdminHandler) GET_AuditLog(c *fiber.Ctx) error {
+	userID := c.Query("user_id")
+	limit, offset := pagination(c)
+	if err := h.audit(c, repositories.AuditActionViewAuditLog, "", map[string]any{"user_id": userID}); err != nil {
+		return err
+	}
+
+	entries, err := h.adminRepository.ListAuditLog(c.UserContext(), userID, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list audit log", "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{
+		"items":  entries,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -1,42 +1,357 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"log/slog"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/devs-group/driplet/api/auth"
+	"github.com/devs-group/driplet/api/consent"
 	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/metrics"
+	"github.com/devs-group/driplet/api/middlewares"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/api/scrub"
+	"github.com/devs-group/driplet/pkg/config"
+	"github.com/devs-group/driplet/pkg/events"
 	"github.com/devs-group/driplet/pkg/pubsub"
 	"github.com/devs-group/godi"
 	"github.com/go-faster/errors"
 	"github.com/gofiber/fiber/v2"
 )
 
+// errDeletionPending rejects data of users that asked for their account to be
+// deleted.
+func errDeletionPending(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "account is scheduled for deletion",
+	})
+}
+
+// errConsentRequired rejects events of users that didn't agree to the current
+// consent version. Clients should ask for consent before sending again.
+func errConsentRequired(c *fiber.Ctx, consentVersion int) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":           consent.ErrConsentRequired.Error(),
+		"consent_version": consentVersion,
+	})
+}
+
+// MaxBatchEvents is the max number of events accepted by the batch endpoint.
+const MaxBatchEvents = 100
+
 type EventsHandler struct {
-	pubsubClient *pubsub.Client
+	pubsubClient           *pubsub.Client
+	scrubber               *scrub.Scrubber
+	userSettingsRepository *repositories.UserSettingsRepository
+	tokenCache             *auth.TokenCache
+	config                 config.API
 }
 
 func NewEventsHandler() (*EventsHandler, error) {
+	cfg, err := godi.Resolve[*config.Config](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve config")
+	}
 	pubsubClient, err := godi.Resolve[*pubsub.Client](di.Container)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve pubsub client")
 	}
-	return &EventsHandler{pubsubClient: pubsubClient}, nil
+	scrubber, err := godi.Resolve[*scrub.Scrubber](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve scrubber")
+	}
+	userSettingsRepository, err := godi.Resolve[*repositories.UserSettingsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve user settings repository")
+	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve token cache")
+	}
+	return &EventsHandler{
+		pubsubClient:           pubsubClient,
+		scrubber:               scrubber,
+		userSettingsRepository: userSettingsRepository,
+		tokenCache:             tokenCache,
+		config:                 cfg.API,
+	}, nil
 }
 
 func (h *EventsHandler) POST_CreateEvent(c *fiber.Ctx) error {
-	publisher, err := h.pubsubClient.NewPublisher("client-events", true)
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+	if u.IsDeletionPending() {
+		return errDeletionPending(c)
+	}
+	meta := eventMetadata(c, u, h.config.IPHashSalt)
+
+	envelope, err := events.Decode(c.Body())
+	if err != nil {
+		var validationErr *events.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":  "invalid event",
+				"fields": validationErr.Fields,
+			})
+		}
+		middlewares.Logger(c).Error("unable to decode event", "err", err)
+		return fiber.ErrBadRequest
+	}
+
+	settings, err := h.userSettings(c, u)
 	if err != nil {
-		slog.Error("failed to create publisher", "err", err)
+		middlewares.Logger(c).Error("unable to get user settings", "user_id", u.ID, "err", err)
 		return fiber.ErrInternalServerError
 	}
-	ctx := context.Background()
-	serverID, err := publisher.Publish(ctx, c.Body(), nil)
+	if err := consent.Check(settings, h.config.ConsentVersion, h.config.RequireConsent); err != nil {
+		return errConsentRequired(c, h.config.ConsentVersion)
+	}
+	meta.ConsentVersion = settings.ConsentVersion
+	if reason := h.filterEvent(settings, envelope, meta); reason != "" {
+		middlewares.Logger(c).Info("event has been dropped", "user_id", u.ID, "reason", reason)
+		metrics.Events.WithLabelValues(metrics.EventDropped).Inc()
+		return c.JSON(fiber.Map{
+			"dropped": true,
+			"reason":  reason,
+		})
+	}
+
+	publisher, err := h.pubsubClient.Publisher(c.UserContext(), events.Topic)
+	if err != nil {
+		middlewares.Logger(c).Error("failed to create publisher", "err", err)
+		return fiber.ErrInternalServerError
+	}
+	// Publishing isn't bound to the request, but the span of the request
+	// remains the parent of the publish span.
+	ctx := context.WithoutCancel(c.UserContext())
+	serverID, err := awaitPublish(ctx, publishEvent(ctx, publisher, envelope, meta))
 	if err != nil {
-		slog.Error("failed to publish event", "err", err)
+		middlewares.Logger(c).Error("failed to publish event", "err", err)
 		return fiber.ErrInternalServerError
 	}
-	slog.Info("event has been published", "server_id", serverID)
+	middlewares.Logger(c).Info("event has been published", "server_id", serverID, "user_id", u.ID, "event_type", envelope.Data.Event)
 	return c.JSON(fiber.Map{
 		"server_id": serverID,
 	})
 }
+
+type BatchEventResult struct {
+	Index    int    `json:"index"`
+	ServerID string `json:"server_id,omitempty"`
+	// Dropped is set for events the user didn't consent to or that come from
+	// denied domains. They are accepted but not published, so clients must
+	// not retry them.
+	Dropped bool                `json:"dropped,omitempty"`
+	Reason  string              `json:"reason,omitempty"`
+	Error   string              `json:"error,omitempty"`
+	Fields  []events.FieldError `json:"fields,omitempty"`
+}
+
+type BatchEventsResponse struct {
+	Published int                `json:"published"`
+	Dropped   int                `json:"dropped"`
+	Failed    int                `json:"failed"`
+	Results   []BatchEventResult `json:"results"`
+}
+
+// POST_CreateEventsBatch accepts a JSON array of events or an NDJSON stream
+// (Content-Type application/x-ndjson) and reports a result per item, so that
+// clients can flush buffered events and retry only the ones that failed.
+func (h *EventsHandler) POST_CreateEventsBatch(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+	if u.IsDeletionPending() {
+		return errDeletionPending(c)
+	}
+	meta := eventMetadata(c, u, h.config.IPHashSalt)
+
+	items, err := splitBatch(c.Body(), strings.HasPrefix(c.Get(fiber.HeaderContentType), "application/x-ndjson"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(items) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "batch must contain at least one event",
+		})
+	}
+	if len(items) > MaxBatchEvents {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("batch must not contain more than %d events", MaxBatchEvents),
+		})
+	}
+
+	settings, err := h.userSettings(c, u)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to get user settings", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	if err := consent.Check(settings, h.config.ConsentVersion, h.config.RequireConsent); err != nil {
+		return errConsentRequired(c, h.config.ConsentVersion)
+	}
+	meta.ConsentVersion = settings.ConsentVersion
+
+	publisher, err := h.pubsubClient.Publisher(c.UserContext(), events.Topic)
+	if err != nil {
+		middlewares.Logger(c).Error("failed to create publisher", "err", err)
+		return fiber.ErrInternalServerError
+	}
+
+	// All valid events are queued first so that they are published together
+	// in as few Pub/Sub batches as possible.
+	ctx := context.WithoutCancel(c.UserContext())
+	results := make([]BatchEventResult, len(items))
+	futures := make([]*publishFuture, len(items))
+	for i, item := range items {
+		results[i].Index = i
+
+		envelope, err := events.Decode(item)
+		if err != nil {
+			var validationErr *events.ValidationError
+			if errors.As(err, &validationErr) {
+				results[i].Error = "invalid event"
+				results[i].Fields = validationErr.Fields
+				continue
+			}
+			results[i].Error = "unable to decode event"
+			continue
+		}
+		if reason := h.filterEvent(settings, envelope, meta); reason != "" {
+			results[i].Dropped = true
+			results[i].Reason = reason
+			metrics.Events.WithLabelValues(metrics.EventDropped).Inc()
+			continue
+		}
+		futures[i] = publishEvent(ctx, publisher, envelope, meta)
+	}
+	for i, future := range futures {
+		if future == nil {
+			continue
+		}
+		serverID, err := awaitPublish(ctx, future)
+		if err != nil {
+			middlewares.Logger(c).Error("failed to publish event", "index", i, "err", err)
+			results[i].Error = "failed to publish event"
+			continue
+		}
+		results[i].ServerID = serverID
+	}
+
+	resp := BatchEventsResponse{Results: results}
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			resp.Failed++
+		case r.Dropped:
+			resp.Dropped++
+		default:
+			resp.Published++
+		}
+	}
+	middlewares.Logger(c).Info("events batch has been published", "user_id", u.ID, "published", resp.Published, "dropped", resp.Dropped, "failed", resp.Failed)
+	return c.JSON(resp)
+}
+
+// userSettings returns the user's settings, cached next to the identity since
+// they are needed on every event.
+func (h *EventsHandler) userSettings(c *fiber.Ctx, u *repositories.User) (*repositories.UserSettings, error) {
+	if settings, ok := h.tokenCache.GetSettings(u.ID); ok {
+		return settings, nil
+	}
+	settings, err := h.userSettingsRepository.Get(c.UserContext(), u.ID)
+	if err != nil {
+		return nil, err
+	}
+	h.tokenCache.SetSettings(u.ID, settings)
+	return settings, nil
+}
+
+// filterEvent applies the user's consent and the scrubbing rules to the event.
+// It returns why the event has to be dropped, or an empty string if it can
+// be published.
+func (h *EventsHandler) filterEvent(settings *repositories.UserSettings, envelope *events.Envelope, meta events.Metadata) string {
+	if err := consent.Apply(settings, h.config.ConsentVersion, h.config.RequireConsent, &envelope.Data, meta.ReceivedAt); err != nil {
+		return err.Error()
+	}
+	if !h.scrubber.Scrub(&envelope.Data) {
+		return "domain is denied"
+	}
+	return ""
+}
+
+type publishFuture struct {
+	result   *pubsub.PublishResult
+	queuedAt time.Time
+}
+
+func publishEvent(ctx context.Context, publisher *pubsub.Publisher, envelope *events.Envelope, meta events.Metadata) *publishFuture {
+	// An envelope only holds strings, numbers and raw json, so it always encodes.
+	data, _ := json.Marshal(envelope)
+	queuedAt := time.Now()
+	return &publishFuture{
+		result:   publisher.PublishAsync(ctx, data, envelope.Attributes(meta)),
+		queuedAt: queuedAt,
+	}
+}
+
+// awaitPublish waits for the event to be published and records the outcome.
+func awaitPublish(ctx context.Context, future *publishFuture) (string, error) {
+	serverID, err := future.result.Get(ctx)
+	if err != nil {
+		metrics.Events.WithLabelValues(metrics.EventFailed).Inc()
+		return "", err
+	}
+	metrics.PublishDuration.Observe(time.Since(future.queuedAt).Seconds())
+	metrics.Events.WithLabelValues(metrics.EventPublished).Inc()
+	return serverID, nil
+}
+
+func eventMetadata(c *fiber.Ctx, u *repositories.User, ipHashSalt string) events.Metadata {
+	return events.Metadata{
+		UserID:       u.ID,
+		ReceivedAt:   time.Now(),
+		ClientIPHash: events.HashClientIP(c.IP(), ipHashSalt),
+		RequestID:    middlewares.RequestID(c),
+	}
+}
+
+// splitBatch splits a batch body into the raw JSON of its events.
+func splitBatch(body []byte, ndjson bool) ([]json.RawMessage, error) {
+	if !ndjson {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, errors.New("body must be a json array of events")
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), events.MaxEventBytes+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(bytes.Clone(line)))
+		if len(items) > MaxBatchEvents {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("body must be newline delimited json events")
+	}
+	return items, nil
+}
@@ -1,15 +1,43 @@
 package handlers
 
 import (
+	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/health"
+	"github.com/devs-group/godi"
+	"github.com/go-faster/errors"
 	"github.com/gofiber/fiber/v2"
 )
 
-type HealthHandler struct{}
+type HealthHandler struct {
+	checker *health.Checker
+}
 
 func NewHealthHandler() (*HealthHandler, error) {
-	return &HealthHandler{}, nil
+	checker, err := godi.Resolve[*health.Checker](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve health checker")
+	}
+	return &HealthHandler{checker: checker}, nil
 }
 
+// GET_health is kept for probes configured before /health/live existed.
 func (h *HealthHandler) GET_health(c *fiber.Ctx) error {
 	return c.SendString("OK")
 }
+
+// GET_Live reports that the process is serving requests. It doesn't check
+// dependencies, so an outage of them doesn't get the instance restarted.
+func (h *HealthHandler) GET_Live(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": health.StatusOK})
+}
+
+// GET_Ready reports whether the dependencies are reachable, answering 503 if
+// any of them isn't.
+func (h *HealthHandler) GET_Ready(c *fiber.Ctx) error {
+	report := h.checker.Report()
+	status := fiber.StatusOK
+	if report.Status != health.StatusOK {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(report)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"crypto/subtle"
+
+	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/pkg/config"
+	"github.com/devs-group/godi"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type MetricsHandler struct {
+	handler fiber.Handler
+	token   string
+}
+
+func NewMetricsHandler() (*MetricsHandler, error) {
+	cfg, err := godi.Resolve[*config.Config](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve config")
+	}
+	return &MetricsHandler{
+		handler: adaptor.HTTPHandler(promhttp.Handler()),
+		token:   cfg.API.MetricsToken,
+	}, nil
+}
+
+// GET_Metrics serves the metrics in the Prometheus format. If METRICS_TOKEN
+// is set it has to be sent as bearer token.
+func (h *MetricsHandler) GET_Metrics(c *fiber.Ctx) error {
+	if h.token != "" {
+		expected := []byte("Bearer " + h.token)
+		if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), expected) != 1 {
+			return fiber.ErrUnauthorized
+		}
+	}
+	return h.handler(c)
+}
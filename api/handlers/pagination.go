@@ -0,0 +1,20 @@
+package handlers
+
+import "github.com/gofiber/fiber/v2"
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pagination reads the limit and offset query parameters, clamping them to
+// sane values.
+func pagination(c *fiber.Ctx) (limit, offset int) {
+	limit = c.QueryInt("limit", defaultPageLimit)
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	limit = min(limit, maxPageLimit)
+	offset = max(c.QueryInt("offset", 0), 0)
+	return limit, offset
+}
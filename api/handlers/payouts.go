@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"github.com/devs-group/driplet/api/auth"
+	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/middlewares"
+	"github.com/devs-group/driplet/api/payouts"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/godi"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PayoutsHandler struct {
+	payoutsService    *payouts.Service
+	payoutsRepository *repositories.PayoutsRepository
+	tokenCache        *auth.TokenCache
+}
+
+func NewPayoutsHandler() (*PayoutsHandler, error) {
+	payoutsService, err := godi.Resolve[*payouts.Service](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve payouts service")
+	}
+	payoutsRepository, err := godi.Resolve[*repositories.PayoutsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve payouts repository")
+	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve token cache")
+	}
+	return &PayoutsHandler{
+		payoutsService:    payoutsService,
+		payoutsRepository: payoutsRepository,
+		tokenCache:        tokenCache,
+	}, nil
+}
+
+func (h *PayoutsHandler) POST_RequestPayout(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	payload := struct {
+		Amount int `json:"amount"`
+	}{}
+	err := c.BodyParser(&payload)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
+		return fiber.ErrBadRequest
+	}
+
+	payout, err := h.payoutsService.Request(c.UserContext(), u, payload.Amount)
+	switch {
+	case errors.Is(err, payouts.ErrPublicKeyNotVerified), errors.Is(err, payouts.ErrAmountTooSmall):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, repositories.ErrInsufficientCredits), errors.Is(err, payouts.ErrDeletionPending):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		middlewares.Logger(c).Error("unable to request payout", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(u.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(payout)
+}
+
+func (h *PayoutsHandler) GET_Payouts(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	limit, offset := pagination(c)
+	items, err := h.payoutsRepository.ListByUser(c.UserContext(), u.ID, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list payouts", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{
+		"items":  items,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
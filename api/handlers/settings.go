@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/devs-group/driplet/api/auth"
+	"github.com/devs-group/driplet/api/consent"
+	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/middlewares"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/pkg/config"
+	"github.com/devs-group/godi"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+)
+
+type SettingsHandler struct {
+	userSettingsRepository *repositories.UserSettingsRepository
+	tokenCache             *auth.TokenCache
+	consentVersion         int
+}
+
+func NewSettingsHandler() (*SettingsHandler, error) {
+	userSettingsRepository, err := godi.Resolve[*repositories.UserSettingsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve user settings repository")
+	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve token cache")
+	}
+	cfg, err := godi.Resolve[*config.Config](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve config")
+	}
+	return &SettingsHandler{
+		userSettingsRepository: userSettingsRepository,
+		tokenCache:             tokenCache,
+		consentVersion:         cfg.API.ConsentVersion,
+	}, nil
+}
+
+type UserSettingsResponse struct {
+	ConsentVersion        int        `json:"consent_version"`
+	ConsentedAt           *time.Time `json:"consented_at"`
+	CurrentConsentVersion int        `json:"current_consent_version"`
+	Categories            []string   `json:"categories"`
+	ExcludedDomains       []string   `json:"excluded_domains"`
+	PauseUntil            *time.Time `json:"pause_until"`
+}
+
+func newUserSettingsResponse(s *repositories.UserSettings, currentConsentVersion int) *UserSettingsResponse {
+	res := &UserSettingsResponse{
+		ConsentVersion:        s.ConsentVersion,
+		CurrentConsentVersion: currentConsentVersion,
+		Categories:            s.Categories,
+		ExcludedDomains:       s.ExcludedDomains,
+	}
+	if s.ConsentedAt.Valid {
+		res.ConsentedAt = &s.ConsentedAt.Time
+	}
+	if s.PauseUntil.Valid {
+		res.PauseUntil = &s.PauseUntil.Time
+	}
+	return res
+}
+
+func (h *SettingsHandler) GET_Settings(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	settings, err := h.userSettingsRepository.Get(c.UserContext(), u.ID)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to get user settings", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(newUserSettingsResponse(settings, h.consentVersion))
+}
+
+// PUT_Settings replaces the user's settings. Consenting means sending the
+// current consent version, sending 0 withdraws the consent.
+func (h *SettingsHandler) PUT_Settings(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	payload := struct {
+		ConsentVersion  int        `json:"consent_version"`
+		Categories      []string   `json:"categories"`
+		ExcludedDomains []string   `json:"excluded_domains"`
+		PauseUntil      *time.Time `json:"pause_until"`
+	}{}
+	if err := c.BodyParser(&payload); err != nil {
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
+		return fiber.ErrBadRequest
+	}
+
+	if payload.ConsentVersion != 0 && payload.ConsentVersion != h.consentVersion {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": fmt.Sprintf("consent_version must be 0 or %d", h.consentVersion),
+		})
+	}
+	categories := pq.StringArray{}
+	for _, category := range payload.Categories {
+		if !slices.Contains(consent.Categories, category) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": fmt.Sprintf("unknown category %q", category),
+			})
+		}
+		if !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	if len(payload.ExcludedDomains) > consent.MaxExcludedDomains {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": fmt.Sprintf("excluded_domains must not contain more than %d domains", consent.MaxExcludedDomains),
+		})
+	}
+	domains := pq.StringArray{}
+	for _, domain := range payload.ExcludedDomains {
+		domain = consent.NormalizeDomain(domain)
+		if domain != "" && !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+
+	settings := &repositories.UserSettings{
+		UserID:          u.ID,
+		ConsentVersion:  payload.ConsentVersion,
+		Categories:      categories,
+		ExcludedDomains: domains,
+	}
+	if payload.PauseUntil != nil {
+		settings.PauseUntil = sql.NullTime{Time: *payload.PauseUntil, Valid: true}
+	}
+	if err := h.userSettingsRepository.Save(c.UserContext(), settings); err != nil {
+		middlewares.Logger(c).Error("unable to save user settings", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(u.ID)
+	middlewares.Logger(c).Info("user settings have been saved", "user_id", u.ID, "consent_version", settings.ConsentVersion)
+
+	return c.JSON(newUserSettingsResponse(settings, h.consentVersion))
+}
@@ -1,17 +1,35 @@
 package handlers
 
 import (
-	"log/slog"
+	"bufio"
+	"context"
+	"fmt"
+	"time"
 
+	"github.com/devs-group/driplet/api/auth"
 	"github.com/devs-group/driplet/api/di"
+	"github.com/devs-group/driplet/api/export"
+	"github.com/devs-group/driplet/api/middlewares"
 	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/api/wallet"
+	"github.com/devs-group/driplet/pkg/config"
+	"github.com/devs-group/driplet/pkg/credits"
 	"github.com/devs-group/godi"
 	"github.com/go-faster/errors"
 	"github.com/gofiber/fiber/v2"
 )
 
+// exportTimeout bounds the time it takes to stream an export, so that a
+// stalled client doesn't hold on to database connections.
+const exportTimeout = 5 * time.Minute
+
 type UsersHandler struct {
-	usersRepository *repositories.UsersRepository
+	usersRepository              *repositories.UsersRepository
+	creditTransactionsRepository *repositories.CreditTransactionsRepository
+	walletChallengesRepository   *repositories.WalletChallengesRepository
+	tokenCache                   *auth.TokenCache
+	exporter                     *export.Exporter
+	deletionGracePeriod          time.Duration
 }
 
 func NewUsersHandler() (*UsersHandler, error) {
@@ -19,16 +37,58 @@ func NewUsersHandler() (*UsersHandler, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to resolve users repository")
 	}
+	creditTransactionsRepository, err := godi.Resolve[*repositories.CreditTransactionsRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve credit transactions repository")
+	}
+	walletChallengesRepository, err := godi.Resolve[*repositories.WalletChallengesRepository](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve wallet challenges repository")
+	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve token cache")
+	}
+	exporter, err := godi.Resolve[*export.Exporter](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve exporter")
+	}
+	cfg, err := godi.Resolve[*config.Config](di.Container)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to resolve config")
+	}
 	return &UsersHandler{
-		usersRepository: usersRepository,
+		usersRepository:              usersRepository,
+		creditTransactionsRepository: creditTransactionsRepository,
+		walletChallengesRepository:   walletChallengesRepository,
+		tokenCache:                   tokenCache,
+		exporter:                     exporter,
+		deletionGracePeriod:          cfg.API.AccountDeletionGracePeriod,
 	}, nil
 }
 
 type GetUserResponse struct {
-	ID        string `json:"id"`
-	Email     string `json:"email"`
-	Credits   int    `json:"credits"`
-	PublicKey string `json:"public_key"`
+	ID                string `json:"id"`
+	Email             string `json:"email"`
+	Credits           int    `json:"credits"`
+	PublicKey         string `json:"public_key"`
+	PublicKeyVerified bool   `json:"public_key_verified"`
+	// PurgeAfter is set while a deletion of the account is pending.
+	PurgeAfter *time.Time `json:"purge_after,omitempty"`
+}
+
+func newGetUserResponse(u *repositories.User) *GetUserResponse {
+	res := &GetUserResponse{
+		ID:                u.ID,
+		Email:             u.Email,
+		Credits:           u.Credits,
+		PublicKey:         u.PublicKey.String,
+		PublicKeyVerified: u.HasVerifiedPublicKey(),
+	}
+	if u.PurgeAfter.Valid {
+		res.PurgeAfter = &u.PurgeAfter.Time
+	}
+	return res
 }
 
 func (h *UsersHandler) GET_User(c *fiber.Ctx) error {
@@ -36,37 +96,229 @@ func (h *UsersHandler) GET_User(c *fiber.Ctx) error {
 	if !ok {
 		return fiber.ErrUnauthorized
 	}
-	return c.JSON(&GetUserResponse{
-		ID:        u.ID,
-		Email:     u.Email,
-		Credits:   u.Credits,
-		PublicKey: u.PublicKey.String,
+	return c.JSON(newGetUserResponse(u))
+}
+
+type PublicKeyChallengeResponse struct {
+	Nonce     string    `json:"nonce"`
+	Message   string    `json:"message"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST_PublicKeyChallenge issues a nonce the user has to sign with the wallet
+// key before PUT_UpdateUsersPublicKey accepts it.
+func (h *UsersHandler) POST_PublicKeyChallenge(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	payload := struct {
+		PublicKey string `json:"public_key"`
+		KeyType   string `json:"key_type"`
+	}{KeyType: wallet.KeyTypeSolana}
+	err := c.BodyParser(&payload)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
+		return fiber.ErrBadRequest
+	}
+	if _, err := wallet.ParsePublicKey(payload.KeyType, payload.PublicKey); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	nonce, err := wallet.NewNonce()
+	if err != nil {
+		middlewares.Logger(c).Error("unable to create challenge nonce", "err", err)
+		return fiber.ErrInternalServerError
+	}
+	challenge := &repositories.WalletChallenge{
+		UserID:    u.ID,
+		PublicKey: payload.PublicKey,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(wallet.ChallengeTTL).Truncate(time.Second),
+	}
+	if err := h.walletChallengesRepository.Create(c.UserContext(), challenge); err != nil {
+		middlewares.Logger(c).Error("unable to create wallet challenge", "err", err)
+		return fiber.ErrInternalServerError
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(&PublicKeyChallengeResponse{
+		Nonce:     challenge.Nonce,
+		Message:   wallet.ChallengeMessage(u.ID, challenge.PublicKey, challenge.Nonce, challenge.ExpiresAt),
+		ExpiresAt: challenge.ExpiresAt,
 	})
 }
 
+// PUT_UpdateUsersPublicKey stores the wallet key once the user signed the
+// challenge issued for it.
 func (h *UsersHandler) PUT_UpdateUsersPublicKey(c *fiber.Ctx) error {
 	u, ok := c.Locals("user").(*repositories.User)
 	if !ok {
-		slog.Error("unable to get user from context")
+		middlewares.Logger(c).Error("unable to get user from context")
 		return fiber.ErrUnauthorized
 	}
 
 	payload := struct {
 		PublicKey string `json:"public_key"`
-	}{}
+		KeyType   string `json:"key_type"`
+		Nonce     string `json:"nonce"`
+		Signature string `json:"signature"`
+	}{KeyType: wallet.KeyTypeSolana}
 	err := c.BodyParser(&payload)
 	if err != nil {
-		slog.Error("unable to parse request body", "err", err)
+		middlewares.Logger(c).Error("unable to parse request body", "err", err)
 		return fiber.ErrBadRequest
 	}
+	publicKey, err := wallet.ParsePublicKey(payload.KeyType, payload.PublicKey)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	challenge, err := h.walletChallengesRepository.FindActive(c.UserContext(), u.ID, payload.Nonce)
+	if errors.Is(err, repositories.ErrChallengeNotFound) || (err == nil && challenge.PublicKey != payload.PublicKey) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challenge not found or expired",
+		})
+	}
+	if err != nil {
+		middlewares.Logger(c).Error("unable to find wallet challenge", "err", err)
+		return fiber.ErrInternalServerError
+	}
 
-	err = h.usersRepository.UpdatePublicKey(u.ID, payload.PublicKey)
+	message := wallet.ChallengeMessage(u.ID, challenge.PublicKey, challenge.Nonce, challenge.ExpiresAt)
+	if err := wallet.VerifySignature(publicKey, message, payload.Signature); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = h.walletChallengesRepository.Complete(c.UserContext(), challenge)
+	if errors.Is(err, repositories.ErrChallengeNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "challenge not found or expired",
+		})
+	}
 	if err != nil {
-		slog.Error("unable to update user public key", "err", err)
+		middlewares.Logger(c).Error("unable to update user public key", "err", err)
 		return fiber.ErrInternalServerError
 	}
+	h.tokenCache.InvalidateUser(u.ID)
 
 	return c.JSON(fiber.Map{
 		"message": "public key updated successfully",
 	})
 }
+
+type GetCreditsHistoryResponse struct {
+	Items  []credits.Transaction `json:"items"`
+	Total  int                   `json:"total"`
+	Limit  int                   `json:"limit"`
+	Offset int                   `json:"offset"`
+}
+
+func (h *UsersHandler) GET_CreditsHistory(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	limit, offset := pagination(c)
+	items, total, err := h.creditTransactionsRepository.ListByUser(c.UserContext(), u.ID, limit, offset)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to list credit transactions", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+
+	return c.JSON(&GetCreditsHistoryResponse{
+		Items:  items,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// GET_Export streams a zip archive of everything stored about the user.
+func (h *UsersHandler) GET_Export(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+	// The user in the context may come from the token cache, the export
+	// should show the current state.
+	user, err := h.usersRepository.FindByID(c.UserContext(), u.ID)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to find user", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Attachment(fmt.Sprintf("driplet-export-%s.zip", time.Now().UTC().Format("20060102")))
+	// The request context is cancelled once the handler returned, so the
+	// export keeps its values, like the trace, and gets a deadline of its own.
+	ctx := context.WithoutCancel(c.UserContext())
+	logger := middlewares.Logger(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+		defer cancel()
+		if err := h.exporter.WriteArchive(ctx, w, user); err != nil {
+			logger.Error("unable to write export", "user_id", user.ID, "err", err)
+		}
+		if err := w.Flush(); err != nil {
+			logger.Error("unable to flush export", "user_id", user.ID, "err", err)
+		}
+	})
+	logger.Info("user data has been exported", "user_id", user.ID)
+	return nil
+}
+
+// DELETE_User schedules the deletion of the account. Events and credits are
+// purged once the grace period has passed, until then the deletion can be
+// cancelled with POST_CancelDeletion.
+func (h *UsersHandler) DELETE_User(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	purgeAfter := time.Now().Add(h.deletionGracePeriod)
+	user, err := h.usersRepository.RequestDeletion(c.UserContext(), u.ID, purgeAfter)
+	if err != nil {
+		middlewares.Logger(c).Error("unable to request user deletion", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(u.ID)
+	middlewares.Logger(c).Info("user deletion has been requested", "user_id", u.ID, "purge_after", user.PurgeAfter.Time)
+
+	return c.Status(fiber.StatusAccepted).JSON(newGetUserResponse(user))
+}
+
+func (h *UsersHandler) POST_CancelDeletion(c *fiber.Ctx) error {
+	u, ok := c.Locals("user").(*repositories.User)
+	if !ok {
+		middlewares.Logger(c).Error("unable to get user from context")
+		return fiber.ErrUnauthorized
+	}
+
+	user, err := h.usersRepository.CancelDeletion(c.UserContext(), u.ID)
+	if errors.Is(err, repositories.ErrDeletionNotPending) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		middlewares.Logger(c).Error("unable to cancel user deletion", "user_id", u.ID, "err", err)
+		return fiber.ErrInternalServerError
+	}
+	h.tokenCache.InvalidateUser(u.ID)
+	middlewares.Logger(c).Info("user deletion has been cancelled", "user_id", u.ID)
+
+	return c.JSON(newGetUserResponse(user))
+}
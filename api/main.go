@@ -1,29 +1,66 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/devs-group/driplet/api/config"
 	"github.com/devs-group/driplet/api/di"
 	"github.com/devs-group/driplet/api/migrations"
+	"github.com/devs-group/driplet/api/payouts"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/api/workers"
+	"github.com/devs-group/driplet/pkg/config"
 	"github.com/devs-group/driplet/pkg/db"
+	"github.com/devs-group/driplet/pkg/events"
+	"github.com/devs-group/driplet/pkg/pubsub"
+	"github.com/devs-group/driplet/pkg/tracing"
+	"github.com/devs-group/godi"
 	"github.com/gofiber/fiber/v2"
 	_ "github.com/lib/pq"
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
+	var cfg *config.Config
+	var shutdownTracing func(context.Context) error
 	app := &cli.App{
 		Name:  "api",
 		Usage: "api for driplet",
+		Flags: config.Flags(),
+		Before: func(c *cli.Context) (err error) {
+			if cfg, err = config.Load(c); err != nil {
+				return err
+			}
+			cfg.Tracing.ServiceName = "driplet-api"
+			shutdownTracing, err = tracing.Setup(c.Context, cfg.Tracing)
+			return err
+		},
+		After: func(c *cli.Context) error {
+			if shutdownTracing == nil {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.API.ShutdownTimeout)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				slog.Error("unable to flush traces", "err", err)
+			}
+			return nil
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "run",
 				Usage: "executes the api",
 				Action: func(c *cli.Context) error {
+					if err := validateConfig(cfg.API.ValidateServer(), cfg.ValidateDatabase(), cfg.ValidatePubSub()); err != nil {
+						return err
+					}
 					app := fiber.New(fiber.Config{
 						ReadBufferSize:  8192,
 						WriteBufferSize: 8192,
@@ -40,11 +77,288 @@ func main() {
 							fiber.MethodPatch,
 							fiber.MethodOptions,
 						},
-						DisableKeepalive: false,
+						DisableKeepalive:   false,
+						ProxyHeader:        cfg.API.ProxyHeader,
+						EnableIPValidation: cfg.API.ProxyHeader != "",
 					})
-					di.Init()       // initializing dependency injection container
-					InitRoutes(app) // initializing http routes
-					return app.Listen(getPort())
+					di.Init(cfg) // initializing dependency injection container
+					// initializing http routes
+					if err := InitRoutes(app); err != nil {
+						return errors.Join(err, di.Close())
+					}
+					return listenAndShutdown(c.Context, app, cfg.API)
+				},
+			},
+			{
+				Name:  "consume-events",
+				Usage: "drains the client-events topic into the events table",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "batch-size",
+						Usage: "number of events written per insert",
+						Value: workers.DefaultEventsWorkerConfig().BatchSize,
+					},
+					&cli.DurationFlag{
+						Name:  "flush-interval",
+						Usage: "max time an event waits for its batch to fill up",
+						Value: workers.DefaultEventsWorkerConfig().FlushInterval,
+					},
+				},
+				Action: func(c *cli.Context) (err error) {
+					if err := validateConfig(cfg.ValidateDatabase(), cfg.ValidatePubSub()); err != nil {
+						return err
+					}
+					ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+
+					di.Init(cfg) // initializing dependency injection container
+					// The worker has flushed its last batch when Run returns,
+					// so the clients can be closed afterwards.
+					defer closeClients(&err)
+					pubsubClient, err := godi.Resolve[*pubsub.Client](di.Container)
+					if err != nil {
+						return fmt.Errorf("failed to resolve pubsub client: %w", err)
+					}
+					eventsRepository, err := godi.Resolve[*repositories.EventsRepository](di.Container)
+					if err != nil {
+						return fmt.Errorf("failed to resolve events repository: %w", err)
+					}
+
+					workerConfig := workers.EventsWorkerConfig{
+						BatchSize:     c.Int("batch-size"),
+						FlushInterval: c.Duration("flush-interval"),
+					}
+					subscriberConfig := cfg.PubSub.DefaultSubscriberConfig
+					// Allow enough messages in flight to fill a batch.
+					subscriberConfig.MaxOutstandingMessages = 2 * workerConfig.BatchSize
+					subscriberConfig.DeadLetterTopic = workers.EventsDeadLetterTopic
+					subscriberConfig.LogAttributes = []string{events.AttrRequestID}
+					// Resolving the publisher creates the topic if it doesn't exist yet.
+					if _, err := pubsubClient.Publisher(ctx, events.Topic); err != nil {
+						return fmt.Errorf("failed to ensure events topic: %w", err)
+					}
+					subscriber, err := pubsubClient.NewSubscriber(events.Topic, workers.EventsSubscription, subscriberConfig, true)
+					if err != nil {
+						return fmt.Errorf("failed to create events subscriber: %w", err)
+					}
+
+					return workers.NewEventsWorker(subscriber, eventsRepository, workerConfig).Run(ctx)
+				},
+			},
+			{
+				Name:  "users",
+				Usage: "user administration commands",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "set-role",
+						Usage:     "changes the role of a user, e.g. to grant the first admin",
+						ArgsUsage: "<email> <user|admin>",
+						Action: func(c *cli.Context) (err error) {
+							if c.NArg() != 2 {
+								return fmt.Errorf("expected an email and a role")
+							}
+							email, role := c.Args().Get(0), c.Args().Get(1)
+							if role != repositories.RoleUser && role != repositories.RoleAdmin {
+								return fmt.Errorf("unknown role %q", role)
+							}
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+
+							di.Init(cfg) // initializing dependency injection container
+							defer closeClients(&err)
+							usersRepository, err := godi.Resolve[*repositories.UsersRepository](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve users repository: %w", err)
+							}
+							adminRepository, err := godi.Resolve[*repositories.AdminRepository](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve admin repository: %w", err)
+							}
+
+							user, err := usersRepository.FindByEmail(c.Context, email)
+							if err != nil {
+								return fmt.Errorf("failed to find user %s: %w", email, err)
+							}
+							entry, err := repositories.NewAuditLogEntry("", repositories.AuditActionSetRole, user.ID, map[string]any{
+								"from": user.Role,
+								"to":   role,
+							})
+							if err != nil {
+								return err
+							}
+							user, err = adminRepository.SetRole(c.Context, entry, role)
+							if err != nil {
+								return err
+							}
+							fmt.Printf("user %s (%s) is now %s\n", user.Email, user.ID, user.Role)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "payouts",
+				Usage: "payout administration commands",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list payouts by status",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "status",
+								Usage: "pending, approved (including unresolved transfers), sent or failed",
+								Value: repositories.PayoutStatusPending,
+							},
+							&cli.IntFlag{
+								Name:  "limit",
+								Value: 50,
+							},
+						},
+						Action: func(c *cli.Context) (err error) {
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							di.Init(cfg) // initializing dependency injection container
+							defer closeClients(&err)
+							payoutsRepository, err := godi.Resolve[*repositories.PayoutsRepository](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve payouts repository: %w", err)
+							}
+							items, err := payoutsRepository.ListByStatus(c.Context, c.String("status"), c.Int("limit"), 0)
+							if err != nil {
+								return err
+							}
+							for _, p := range items {
+								fmt.Printf("%s\t%s\t%d\t%s\t%s\n", p.ID, p.UserID, p.Amount, p.Status, p.CreatedAt.Format(time.RFC3339))
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "settle",
+						Usage:     "approve a pending payout and transfer the tokens",
+						ArgsUsage: "<payout id>",
+						Action: func(c *cli.Context) (err error) {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected a payout id")
+							}
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							di.Init(cfg) // initializing dependency injection container
+							defer closeClients(&err)
+							payoutsService, err := godi.Resolve[*payouts.Service](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve payouts service: %w", err)
+							}
+							payout, err := payoutsService.Settle(c.Context, &repositories.PayoutAudit{
+								Action: repositories.AuditActionSettlePayout,
+							}, c.Args().First())
+							if err != nil {
+								return err
+							}
+							if payout.TransferError != nil {
+								fmt.Printf("payout %s is %s, the transfer outcome is unknown: %s\n", payout.ID, payout.Status, *payout.TransferError)
+								fmt.Println("check the transfer on-chain and run payouts resolve")
+								return nil
+							}
+							fmt.Printf("payout %s is %s\n", payout.ID, payout.Status)
+							return nil
+						},
+					},
+					{
+						Name:      "resolve",
+						Usage:     "resolve an approved payout whose transfer outcome is unknown",
+						ArgsUsage: "<payout id>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "tx-signature",
+								Usage: "signature of the transaction that sent the tokens",
+							},
+							&cli.BoolFlag{
+								Name:  "failed",
+								Usage: "the tokens have not been sent, fail the payout and refund the credits",
+							},
+							&cli.StringFlag{
+								Name:  "reason",
+								Usage: "reason shown to the user if the payout failed",
+							},
+						},
+						Action: func(c *cli.Context) (err error) {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected a payout id")
+							}
+							if (c.String("tx-signature") == "") == !c.Bool("failed") {
+								return fmt.Errorf("expected either --tx-signature or --failed")
+							}
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							di.Init(cfg) // initializing dependency injection container
+							defer closeClients(&err)
+							payoutsService, err := godi.Resolve[*payouts.Service](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve payouts service: %w", err)
+							}
+							payout, err := payoutsService.Resolve(c.Context, &repositories.PayoutAudit{
+								Action: repositories.AuditActionResolvePayout,
+								Reason: c.String("reason"),
+							}, c.Args().First(), !c.Bool("failed"), c.String("tx-signature"))
+							if err != nil {
+								return err
+							}
+							fmt.Printf("payout %s is %s\n", payout.ID, payout.Status)
+							return nil
+						},
+					},
+					{
+						Name:      "reject",
+						Usage:     "reject a pending payout and refund the credits",
+						ArgsUsage: "<payout id>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "reason",
+								Usage: "reason shown to the user",
+							},
+						},
+						Action: func(c *cli.Context) (err error) {
+							if c.NArg() != 1 {
+								return fmt.Errorf("expected a payout id")
+							}
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							di.Init(cfg) // initializing dependency injection container
+							defer closeClients(&err)
+							payoutsService, err := godi.Resolve[*payouts.Service](di.Container)
+							if err != nil {
+								return fmt.Errorf("failed to resolve payouts service: %w", err)
+							}
+							payout, err := payoutsService.Reject(c.Context, &repositories.PayoutAudit{
+								Action: repositories.AuditActionRejectPayout,
+								Reason: c.String("reason"),
+							}, c.Args().First())
+							if err != nil {
+								return err
+							}
+							fmt.Printf("payout %s is %s\n", payout.ID, payout.Status)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "configuration commands",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "print",
+						Usage: "print the effective configuration with secrets redacted",
+						Action: func(c *cli.Context) error {
+							return cfg.Print(os.Stdout)
+						},
+					},
 				},
 			},
 			{
@@ -70,7 +384,10 @@ func main() {
 						Name:  "up",
 						Usage: "run all pending migrations",
 						Action: func(c *cli.Context) error {
-							database, err := db.Connect(db.DefaultConfig())
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							database, err := db.Connect(cfg.Database)
 							if err != nil {
 								return fmt.Errorf("failed to connect to database: %w", err)
 							}
@@ -82,7 +399,10 @@ func main() {
 						Name:  "down",
 						Usage: "rollback the last migration",
 						Action: func(c *cli.Context) error {
-							database, err := db.Connect(db.DefaultConfig())
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							database, err := db.Connect(cfg.Database)
 							if err != nil {
 								return fmt.Errorf("failed to connect to database: %w", err)
 							}
@@ -94,7 +414,10 @@ func main() {
 						Name:  "reset",
 						Usage: "rollback all migrations",
 						Action: func(c *cli.Context) error {
-							database, err := db.Connect(db.DefaultConfig())
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							database, err := db.Connect(cfg.Database)
 							if err != nil {
 								return fmt.Errorf("failed to connect to database: %w", err)
 							}
@@ -106,7 +429,10 @@ func main() {
 						Name:  "status",
 						Usage: "print migrations status",
 						Action: func(c *cli.Context) error {
-							database, err := db.Connect(db.DefaultConfig())
+							if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+								return err
+							}
+							database, err := db.Connect(cfg.Database)
 							if err != nil {
 								return fmt.Errorf("failed to connect to database: %w", err)
 							}
@@ -125,14 +451,52 @@ func main() {
 	}
 }
 
-func getPort() string {
-	port := 9000
-	if config.PORT != "" {
-		var err error
-		port, err = strconv.Atoi(config.PORT)
-		if err != nil {
-			log.Fatal("PORT env var must be a number parsable to an int")
+// validateConfig fails a command if it lacks settings it needs.
+func validateConfig(errs ...error) error {
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// closeClients closes the clients a command has created and adds the error
+// to the command's result.
+func closeClients(err *error) {
+	if closeErr := di.Close(); closeErr != nil {
+		*err = errors.Join(*err, fmt.Errorf("failed to close clients: %w", closeErr))
+	}
+}
+
+// listenAndShutdown serves the app until SIGINT or SIGTERM, then stops
+// accepting connections and gives in-flight requests SHUTDOWN_TIMEOUT to
+// finish before the pubsub and database clients are closed.
+func listenAndShutdown(ctx context.Context, app *fiber.App, cfg config.API) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(fmt.Sprintf(":%d", cfg.Port))
+	}()
+
+	select {
+	case err := <-listenErr:
+		if closeErr := di.Close(); closeErr != nil {
+			slog.Error("unable to close clients", "err", closeErr)
 		}
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process.
+	stop()
+
+	slog.Info("received shutdown signal, draining requests...", "timeout", cfg.ShutdownTimeout)
+	if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+		slog.Error("unable to drain requests in time", "err", err)
+	}
+	if err := di.Close(); err != nil {
+		return fmt.Errorf("failed to close clients: %w", err)
 	}
-	return fmt.Sprintf(":%d", port)
+	slog.Info("api has been shut down")
+	return nil
 }
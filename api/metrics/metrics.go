@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/devs-group/driplet/api/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "driplet_api"
+
+// Outcomes of the authentication of a request.
+const (
+	AuthMissingHeader = "missing_header"
+	AuthInvalidFormat = "invalid_format"
+	AuthCached        = "cached"
+	AuthValid         = "valid"
+	AuthInvalidToken  = "invalid_token"
+	AuthDisabled      = "disabled"
+	AuthError         = "error"
+)
+
+// Outcomes of received events.
+const (
+	EventPublished = "published"
+	EventFailed    = "failed"
+	EventDropped   = "dropped"
+)
+
+var (
+	// Requests are labeled with the route pattern instead of the path, so that
+	// ids in paths don't create new series.
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Handled HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	AuthValidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_validations_total",
+		Help:      "Authentications of requests by outcome.",
+	}, []string{"outcome"})
+	UsersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "users_created_total",
+		Help:      "Users created on their first authenticated request.",
+	})
+
+	Events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Received events by outcome.",
+	}, []string{"outcome"})
+	PublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_publish_duration_seconds",
+		Help:      "Time from queueing an event for Pub/Sub until the publish completed.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+)
+
+// RegisterDB exports the connection pool stats of the database.
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// RegisterTokenCache exports the hits, misses and size of the token cache.
+func RegisterTokenCache(cache *auth.TokenCache) {
+	prometheus.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_cache_hits_total",
+			Help:      "Lookups of validated tokens that were served from the cache.",
+		}, func() float64 { return float64(cache.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_cache_misses_total",
+			Help:      "Lookups of validated tokens that missed the cache or found an expired entry.",
+		}, func() float64 { return float64(cache.Stats().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "token_cache_entries",
+			Help:      "Validated tokens currently held in the cache.",
+		}, func() float64 { return float64(cache.Stats().Entries) }),
+	)
+}
@@ -1,17 +1,22 @@
 package middlewares
 
 import (
-	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/devs-group/driplet/api/auth"
+	"github.com/devs-group/driplet/api/metrics"
 	"github.com/devs-group/driplet/api/repositories"
+	"github.com/go-faster/errors"
 	"github.com/gofiber/fiber/v2"
 )
 
 type AuthConfig struct {
 	TokenValidator  *auth.TokenValidator
 	UsersRepository *repositories.UsersRepository
+	// TokenCache is optional, without it every request validates the token
+	// and loads the user.
+	TokenCache *auth.TokenCache
 }
 
 func RequireAuth(config AuthConfig) fiber.Handler {
@@ -19,6 +24,7 @@ func RequireAuth(config AuthConfig) fiber.Handler {
 		// Get token from Authorization header
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
+			metrics.AuthValidations.WithLabelValues(metrics.AuthMissingHeader).Inc()
 			return c.Status(401).JSON(fiber.Map{
 				"error": "Authorization header required",
 			})
@@ -27,34 +33,64 @@ func RequireAuth(config AuthConfig) fiber.Handler {
 		// Extract token from "Bearer <token>"
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		if token == authHeader {
+			metrics.AuthValidations.WithLabelValues(metrics.AuthInvalidFormat).Inc()
 			return c.Status(401).JSON(fiber.Map{
 				"error": "Invalid authorization format",
 			})
 		}
 
+		if config.TokenCache != nil {
+			if identity, ok := config.TokenCache.Get(token); ok {
+				if identity.User.IsDisabled() {
+					return accountDisabled(c)
+				}
+				metrics.AuthValidations.WithLabelValues(metrics.AuthCached).Inc()
+				c.Locals("user", identity.User)
+				return c.Next()
+			}
+		}
+
 		// Validate Google token
 		claims, err := config.TokenValidator.ValidateGoogleToken(token)
 		if err != nil {
+			metrics.AuthValidations.WithLabelValues(metrics.AuthInvalidToken).Inc()
 			return c.Status(401).JSON(fiber.Map{
 				"error": "Invalid token",
 			})
 		}
 
-		// Get or create user
-		user, err := config.UsersRepository.FindByEmail(claims.Email)
-		if err != nil {
-			slog.Error("unable to find user by email", "email", claims.Email, "err", err)
-			// If user doesn't exist, create them
-			user = &repositories.User{
-				Email:   claims.Email,
-				OAuthID: claims.GoogleID,
-			}
-			if err := config.UsersRepository.Create(user); err != nil {
-				slog.Error("unable to create user while auth", "err", err)
+		// Get or create user, keyed on the Google subject since emails can change
+		user, err := config.UsersRepository.FindByOAuthID(c.UserContext(), claims.GoogleID)
+		if err != nil && !errors.Is(err, repositories.ErrUserNotFound) {
+			Logger(c).Error("unable to find user by oauth id", "err", err)
+			metrics.AuthValidations.WithLabelValues(metrics.AuthError).Inc()
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to load user",
+			})
+		}
+		if err != nil || user.Email != claims.Email {
+			var created bool
+			user, created, err = config.UsersRepository.UpsertByOAuthID(c.UserContext(), claims.GoogleID, claims.Email)
+			if err != nil {
+				Logger(c).Error("unable to upsert user while auth", "err", err)
+				metrics.AuthValidations.WithLabelValues(metrics.AuthError).Inc()
 				return c.Status(500).JSON(fiber.Map{
 					"error": "Failed to create user",
 				})
 			}
+			if created {
+				Logger(c).Info("user has been created", "user_id", user.ID)
+				metrics.UsersCreated.Inc()
+			}
+		}
+
+		if user.IsDisabled() {
+			return accountDisabled(c)
+		}
+		metrics.AuthValidations.WithLabelValues(metrics.AuthValid).Inc()
+
+		if config.TokenCache != nil {
+			config.TokenCache.Set(token, auth.CachedIdentity{Claims: claims, User: user})
 		}
 
 		// Attach user to context
@@ -63,3 +99,29 @@ func RequireAuth(config AuthConfig) fiber.Handler {
 		return c.Next()
 	}
 }
+
+func accountDisabled(c *fiber.Ctx) error {
+	metrics.AuthValidations.WithLabelValues(metrics.AuthDisabled).Inc()
+	return c.Status(403).JSON(fiber.Map{
+		"error": "Account disabled",
+	})
+}
+
+// RequireRole only lets users with one of the roles through. It has to run
+// after RequireAuth.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(*repositories.User)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+		if !slices.Contains(roles, user.Role) {
+			return c.Status(403).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIDLength bounds request ids taken from clients, since they end up
+// in logs and message attributes.
+const maxRequestIDLength = 128
+
+// RequestLogger assigns every request an id, taken from the X-Request-ID
+// header if the client or a proxy sent one, and echoes it in the response.
+// It stores the id and a logger carrying it, and the trace id if the request
+// is traced, in the locals "request_id" and "logger", and logs every request
+// once it has been answered.
+func RequestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		startedAt := time.Now()
+		id := c.Get(fiber.HeaderXRequestID)
+		if !validRequestID(id) {
+			id = utils.UUIDv4()
+		}
+		c.Set(fiber.HeaderXRequestID, id)
+		logger := slog.Default().With("request_id", id)
+		if spanContext := trace.SpanContextFromContext(c.UserContext()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String())
+		}
+		c.Locals("request_id", id)
+		c.Locals("logger", logger)
+
+		// Errors are handled here so that the logged status is the one sent.
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		attrs := []any{
+			"method", c.Method(),
+			"route", c.Route().Path,
+			"status", status,
+			"latency", time.Since(startedAt),
+		}
+		if user, ok := c.Locals("user").(*repositories.User); ok {
+			attrs = append(attrs, "user_id", user.ID)
+		}
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(c.UserContext(), level, "request has been handled", attrs...)
+		return nil
+	}
+}
+
+// Logger returns the request's logger, or the default logger outside of
+// RequestLogger.
+func Logger(c *fiber.Ctx) *slog.Logger {
+	if logger, ok := c.Locals("logger").(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the id assigned by RequestLogger.
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("request_id").(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
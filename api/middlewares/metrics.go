@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/devs-group/driplet/api/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Metrics records the count and latency of requests. It has to run before
+// RequestLogger, which turns errors into responses.
+func Metrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		startedAt := time.Now()
+		err := c.Next()
+
+		labels := []string{c.Method(), c.Route().Path, strconv.Itoa(c.Response().StatusCode())}
+		metrics.Requests.WithLabelValues(labels...).Inc()
+		metrics.RequestDuration.WithLabelValues(labels...).Observe(time.Since(startedAt).Seconds())
+		return err
+	}
+}
@@ -0,0 +1,116 @@
+package middlewares
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devs-group/driplet/api/ratelimit"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ViolationRecorder counts the rate limit violations of a user and reports
+// whether the user has been flagged, see UsersRepository.
+type ViolationRecorder interface {
+	RecordRateLimitViolation(ctx context.Context, userID string, threshold int) (bool, error)
+}
+
+type RateLimitConfig struct {
+	Limiter *ratelimit.Limiter
+	// Violations records violations of users, flagging those with
+	// FlagThreshold violations. It is optional.
+	Violations    ViolationRecorder
+	FlagThreshold int
+}
+
+// violationCooldown is the min time between two recorded violations of a
+// user, so that a flood of limited requests doesn't turn into database writes.
+const violationCooldown = time.Minute
+
+// RateLimitByIP limits the requests per client IP. It can run before
+// RequireAuth to protect token validation.
+func RateLimitByIP(config RateLimitConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+		allowed, retryAfter := takeToken(c, config.Limiter, ratelimit.ScopeIP, c.IP())
+		if !allowed {
+			return tooManyRequests(c, retryAfter)
+		}
+		return c.Next()
+	}
+}
+
+// RateLimitByUser limits the requests per user. It has to run after
+// RequireAuth.
+func RateLimitByUser(config RateLimitConfig) fiber.Handler {
+	var mu sync.Mutex
+	lastViolations := map[string]time.Time{}
+
+	recordViolation := func(c *fiber.Ctx, userID string) {
+		if config.Violations == nil {
+			return
+		}
+		now := config.Limiter.Now()
+		mu.Lock()
+		if now.Sub(lastViolations[userID]) < violationCooldown {
+			mu.Unlock()
+			return
+		}
+		for id, t := range lastViolations {
+			if now.Sub(t) >= violationCooldown {
+				delete(lastViolations, id)
+			}
+		}
+		lastViolations[userID] = now
+		mu.Unlock()
+
+		flagged, err := config.Violations.RecordRateLimitViolation(c.UserContext(), userID, config.FlagThreshold)
+		if err != nil {
+			Logger(c).Error("unable to record rate limit violation", "user_id", userID, "err", err)
+			return
+		}
+		if flagged {
+			Logger(c).Warn("user has been flagged for repeated rate limit violations", "user_id", userID)
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+		user, ok := c.Locals("user").(*repositories.User)
+		if !ok {
+			return c.Next()
+		}
+		allowed, retryAfter := takeToken(c, config.Limiter, ratelimit.ScopeUser, user.ID)
+		if !allowed {
+			recordViolation(c, user.ID)
+			return tooManyRequests(c, retryAfter)
+		}
+		return c.Next()
+	}
+}
+
+// takeToken fails open, an unavailable store must not take the API down.
+func takeToken(c *fiber.Ctx, limiter *ratelimit.Limiter, scope, subject string) (bool, time.Duration) {
+	allowed, retryAfter, err := limiter.Take(c.UserContext(), scope, subject, c.Method(), c.Path())
+	if err != nil {
+		Logger(c).Error("unable to check rate limit", "err", err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+func tooManyRequests(c *fiber.Ctx, retryAfter time.Duration) error {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many requests",
+		"retry_after": seconds,
+	})
+}
@@ -0,0 +1,174 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devs-group/driplet/api/ratelimit"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type fakeViolations struct {
+	userIDs []string
+}
+
+func (f *fakeViolations) RecordRateLimitViolation(ctx context.Context, userID string, threshold int) (bool, error) {
+	f.userIDs = append(f.userIDs, userID)
+	return len(f.userIDs) >= threshold, nil
+}
+
+// newRateLimitedApp serves /test for the user in the X-User-ID header,
+// limited to a request an hour per user and ipRequests per IP, on a clock the
+// test controls.
+func newRateLimitedApp(now *time.Time, violations *fakeViolations, ipRequests int) *fiber.App {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.Rules{
+		Default: ratelimit.RouteLimits{
+			User: ratelimit.Limit{Requests: 1, Per: time.Hour},
+			IP:   ratelimit.Limit{Requests: ipRequests, Per: time.Hour},
+		},
+	})
+	limiter.Now = func() time.Time { return *now }
+	config := RateLimitConfig{
+		Limiter:       limiter,
+		Violations:    violations,
+		FlagThreshold: 10,
+	}
+
+	app := fiber.New()
+	app.Use(RateLimitByIP(config))
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", &repositories.User{ID: c.Get("X-User-ID", "1")})
+		return c.Next()
+	})
+	app.Use(RateLimitByUser(config))
+	app.All("/test", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	return app
+}
+
+type rateLimitResponse struct {
+	status     int
+	retryAfter string
+	body       struct {
+		RetryAfter int `json:"retry_after"`
+	}
+}
+
+func doRequest(t *testing.T, app *fiber.App, method, userID string) rateLimitResponse {
+	t.Helper()
+	req := httptest.NewRequest(method, "/test", nil)
+	req.Header.Set("X-User-ID", userID)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	res := rateLimitResponse{status: resp.StatusCode, retryAfter: resp.Header.Get(fiber.HeaderRetryAfter)}
+	if resp.StatusCode == fiber.StatusTooManyRequests {
+		if err := json.NewDecoder(resp.Body).Decode(&res.body); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	return res
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	violations := &fakeViolations{}
+	app := newRateLimitedApp(&now, violations, 100)
+
+	if res := doRequest(t, app, fiber.MethodGet, "1"); res.status != fiber.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", res.status)
+	}
+
+	res := doRequest(t, app, fiber.MethodGet, "1")
+	if res.status != fiber.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", res.status)
+	}
+	if res.retryAfter != "3600" || res.body.RetryAfter != 3600 {
+		t.Errorf("expected to retry after 3600 seconds, got header %q and body %d", res.retryAfter, res.body.RetryAfter)
+	}
+	if len(violations.userIDs) != 1 || violations.userIDs[0] != "1" {
+		t.Errorf("expected a violation of user 1, got %v", violations.userIDs)
+	}
+}
+
+func TestRateLimitRetryAfterRoundsUp(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	app := newRateLimitedApp(&now, &fakeViolations{}, 100)
+
+	doRequest(t, app, fiber.MethodGet, "1")
+	tests := map[string]struct {
+		after time.Duration
+		want  string
+	}{
+		"fraction":       {time.Hour - 1500*time.Millisecond, "2"},
+		"below a second": {time.Hour - time.Millisecond, "1"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			now = time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC).Add(tt.after)
+			res := doRequest(t, app, fiber.MethodGet, "1")
+			if res.status != fiber.StatusTooManyRequests || res.retryAfter != tt.want {
+				t.Errorf("expected 429 with Retry-After %s, got %d with %q", tt.want, res.status, res.retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimitViolationCooldown(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	violations := &fakeViolations{}
+	app := newRateLimitedApp(&now, violations, 100)
+
+	steps := []struct {
+		after time.Duration
+		want  int
+	}{
+		{0, 0},
+		{time.Second, 1},
+		{2 * time.Second, 1},
+		{30 * time.Second, 1},
+		{time.Second + violationCooldown, 2},
+		{time.Second + violationCooldown + time.Second, 2},
+	}
+	start := now
+	for i, s := range steps {
+		now = start.Add(s.after)
+		doRequest(t, app, fiber.MethodGet, "1")
+		if len(violations.userIDs) != s.want {
+			t.Errorf("step %d: expected %d recorded violations, got %d", i, s.want, len(violations.userIDs))
+		}
+	}
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	violations := &fakeViolations{}
+	app := newRateLimitedApp(&now, violations, 2)
+
+	// Preflight requests don't count.
+	for range 3 {
+		if res := doRequest(t, app, fiber.MethodOptions, "1"); res.status == fiber.StatusTooManyRequests {
+			t.Fatal("expected preflight requests not to be limited")
+		}
+	}
+	for _, userID := range []string{"1", "2"} {
+		if res := doRequest(t, app, fiber.MethodGet, userID); res.status != fiber.StatusOK {
+			t.Fatalf("expected the request of user %s to pass, got %d", userID, res.status)
+		}
+	}
+	res := doRequest(t, app, fiber.MethodGet, "3")
+	if res.status != fiber.StatusTooManyRequests || res.retryAfter != "1800" {
+		t.Errorf("expected 429 with Retry-After 1800, got %d with %q", res.status, res.retryAfter)
+	}
+	if len(violations.userIDs) != 0 {
+		t.Errorf("expected violations of the IP limit not to be recorded for users, got %v", violations.userIDs)
+	}
+}
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    message_id VARCHAR(255) UNIQUE,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    website VARCHAR(255),
+    url TEXT,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW (),
+    created_at TIMESTAMPTZ DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS events_user_id_received_at_idx ON events (user_id, received_at);
+
+CREATE INDEX IF NOT EXISTS events_received_at_idx ON events (received_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS events;
+
+-- +goose StatementEnd
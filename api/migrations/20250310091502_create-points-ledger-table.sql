@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS points_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    users INTEGER NOT NULL DEFAULT 0,
+    points INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+CREATE TABLE IF NOT EXISTS points_ledger (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    run_id UUID NOT NULL REFERENCES points_runs (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    points INTEGER NOT NULL,
+    events INTEGER NOT NULL,
+    unique_sites INTEGER NOT NULL,
+    time_spent_seconds INTEGER NOT NULL,
+    capped BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS points_ledger_user_id_window_idx ON points_ledger (user_id, window_start, window_end);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS points_ledger;
+
+DROP TABLE IF EXISTS points_runs;
+
+-- +goose StatementEnd
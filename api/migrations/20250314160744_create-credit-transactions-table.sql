@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS credit_transactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    reason VARCHAR(64) NOT NULL,
+    source_job_run UUID REFERENCES points_runs (id) ON DELETE SET NULL,
+    idempotency_key VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS credit_transactions_user_id_created_at_idx ON credit_transactions (user_id, created_at);
+
+UPDATE users SET credits = 0 WHERE credits IS NULL;
+
+-- Existing balances have no history, record them as opening balances so that
+-- the ledger always sums up to users.credits.
+INSERT INTO credit_transactions (user_id, amount, reason, idempotency_key)
+SELECT id, credits, 'opening_balance', 'opening-balance:' || id
+FROM users
+WHERE credits <> 0;
+
+ALTER TABLE users
+ALTER COLUMN credits SET NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+ALTER COLUMN credits DROP NOT NULL;
+
+DROP TABLE IF EXISTS credit_transactions;
+
+-- +goose StatementEnd
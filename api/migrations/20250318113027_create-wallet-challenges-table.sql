@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS wallet_challenges (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4 (),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key VARCHAR(255) NOT NULL,
+    nonce VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS wallet_challenges_user_id_idx ON wallet_challenges (user_id);
+
+-- Keys stored before ownership proofs existed stay unverified.
+ALTER TABLE users
+ADD COLUMN public_key_verified_at TIMESTAMPTZ DEFAULT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN public_key_verified_at;
+
+DROP TABLE IF EXISTS wallet_challenges;
+
+-- +goose StatementEnd
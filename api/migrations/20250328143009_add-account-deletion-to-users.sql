@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A user that requested deletion is purged once purge_after has passed,
+-- until then the request can be cancelled.
+ALTER TABLE users
+ADD COLUMN deletion_requested_at TIMESTAMPTZ DEFAULT NULL,
+ADD COLUMN purge_after TIMESTAMPTZ DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS users_purge_after_idx ON users (purge_after)
+WHERE
+    purge_after IS NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_purge_after_idx;
+
+ALTER TABLE users
+DROP COLUMN purge_after,
+DROP COLUMN deletion_requested_at;
+
+-- +goose StatementEnd
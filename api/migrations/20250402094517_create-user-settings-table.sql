@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    -- 0 means the user hasn't consented to any version yet.
+    consent_version INTEGER NOT NULL DEFAULT 0,
+    consented_at TIMESTAMPTZ,
+    categories TEXT[] NOT NULL DEFAULT '{}',
+    excluded_domains TEXT[] NOT NULL DEFAULT '{}',
+    pause_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW (),
+    updated_at TIMESTAMPTZ DEFAULT NOW ()
+);
+
+-- The consent version the user had agreed to when the event was received.
+ALTER TABLE events
+ADD COLUMN consent_version INTEGER;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE events
+DROP COLUMN consent_version;
+
+DROP TABLE IF EXISTS user_settings;
+
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- tat is the theoretical arrival time of the next request in unix
+-- microseconds, see api/ratelimit.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY,
+    tat BIGINT NOT NULL
+);
+
+ALTER TABLE users
+ADD COLUMN rate_limit_violations INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN rate_limit_flagged_at TIMESTAMPTZ DEFAULT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN rate_limit_flagged_at,
+DROP COLUMN rate_limit_violations;
+
+DROP TABLE IF EXISTS rate_limit_buckets;
+
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Points of flagged users are withheld: the ledger row is written with the
+-- review_status 'pending' and credited only once an admin releases it.
+ALTER TABLE points_ledger
+ADD COLUMN fraud_score INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN fraud_signals JSONB NOT NULL DEFAULT '{}',
+ADD COLUMN review_status VARCHAR(16) DEFAULT NULL CHECK (review_status IN ('pending', 'released', 'rejected')),
+ADD COLUMN reviewed_by UUID DEFAULT NULL REFERENCES users (id) ON DELETE SET NULL,
+ADD COLUMN reviewed_at TIMESTAMPTZ DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS points_ledger_pending_review_idx ON points_ledger (user_id) WHERE review_status = 'pending';
+
+ALTER TABLE points_runs
+ADD COLUMN withheld_users INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN withheld_points INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE points_runs
+DROP COLUMN withheld_points,
+DROP COLUMN withheld_users;
+
+DROP INDEX IF EXISTS points_ledger_pending_review_idx;
+
+ALTER TABLE points_ledger
+DROP COLUMN reviewed_at,
+DROP COLUMN reviewed_by,
+DROP COLUMN review_status,
+DROP COLUMN fraud_signals,
+DROP COLUMN fraud_score;
+
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Set on approved payouts whose transfer failed without proving that no
+-- tokens were sent. They stay approved until an admin resolves them.
+ALTER TABLE payouts
+ADD COLUMN transfer_error TEXT;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE payouts
+DROP COLUMN transfer_error;
+
+-- +goose StatementEnd
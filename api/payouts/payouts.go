@@ -0,0 +1,182 @@
+package payouts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+var (
+	ErrPublicKeyNotVerified = repositories.ErrPublicKeyNotVerified
+	ErrAmountTooSmall       = errors.New("amount is below the minimum payout")
+	ErrTransfersDisabled    = errors.New("no transferer is configured")
+	ErrDeletionPending      = repositories.ErrDeletionPending
+	ErrSignatureRequired    = errors.New("a transaction signature is required to resolve a payout as sent")
+
+	// ErrTransferNotSent is wrapped by Transferer errors that guarantee that
+	// no tokens have been sent, e.g. because the transaction was rejected
+	// before it was submitted. Only those errors refund a payout.
+	ErrTransferNotSent = errors.New("tokens have not been sent")
+)
+
+// TransferRequest describes an on-chain transfer of $DRIPL.
+type TransferRequest struct {
+	// PayoutID is passed along so that implementations can deduplicate
+	// retried transfers.
+	PayoutID string
+	To       string
+	Amount   int
+}
+
+// Transferer sends tokens on-chain and returns the transaction signature.
+// Errors that don't wrap ErrTransferNotSent leave it open whether the tokens
+// have been sent, e.g. a timeout after submitting the transaction.
+type Transferer interface {
+	Transfer(ctx context.Context, req TransferRequest) (string, error)
+}
+
+// FakeTransferer pretends to transfer tokens, for local development.
+type FakeTransferer struct{}
+
+func (FakeTransferer) Transfer(ctx context.Context, req TransferRequest) (string, error) {
+	signature := "fake-" + utils.UUIDv4()
+	slog.Info("faking payout transfer", "payout_id", req.PayoutID, "to", req.To, "amount", req.Amount, "signature", signature)
+	return signature, nil
+}
+
+type Service struct {
+	payoutsRepository *repositories.PayoutsRepository
+	// transferer is nil if transfers are disabled.
+	transferer Transferer
+	minAmount  int
+}
+
+func NewService(payoutsRepository *repositories.PayoutsRepository, transferer Transferer, minAmount int) *Service {
+	return &Service{
+		payoutsRepository: payoutsRepository,
+		transferer:        transferer,
+		minAmount:         minAmount,
+	}
+}
+
+// Request creates a pending payout to the user's verified public key and
+// reserves the credits. The key is read from the locked user row rather than
+// from user, which may be a cached copy.
+func (s *Service) Request(ctx context.Context, user *repositories.User, amount int) (*repositories.Payout, error) {
+	if amount < max(s.minAmount, 1) {
+		return nil, ErrAmountTooSmall
+	}
+
+	payout := &repositories.Payout{
+		UserID: user.ID,
+		Amount: amount,
+	}
+	if err := s.payoutsRepository.Create(ctx, payout); err != nil {
+		return nil, err
+	}
+	slog.Info("payout has been requested", "payout_id", payout.ID, "user_id", user.ID, "amount", amount)
+	return payout, nil
+}
+
+// Settle approves a pending payout and transfers the tokens. If the
+// transfer provably didn't send any tokens the payout is failed and the
+// credits are refunded. On any other transfer error the payout stays approved
+// with the error recorded, since refunding could pay the user twice, and has
+// to be settled with Resolve once the transfer has been checked on-chain.
+// Every change is audited on behalf of audit.
+func (s *Service) Settle(ctx context.Context, audit *repositories.PayoutAudit, payoutID string) (*repositories.Payout, error) {
+	if s.transferer == nil {
+		return nil, ErrTransfersDisabled
+	}
+
+	payout, err := s.payoutsRepository.Transition(ctx, audit, payoutID, repositories.PayoutStatusPending, repositories.PayoutStatusApproved)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := s.transferer.Transfer(ctx, TransferRequest{
+		PayoutID: payout.ID,
+		To:       payout.PublicKey,
+		Amount:   payout.Amount,
+	})
+	if errors.Is(err, ErrTransferNotSent) {
+		slog.Error("payout transfer failed", "payout_id", payout.ID, "err", err)
+		failed, failErr := s.payoutsRepository.MarkFailed(ctx, audit, payout.ID, repositories.PayoutStatusApproved, fmt.Sprintf("transfer failed: %s", err))
+		if failErr != nil {
+			return nil, errors.Wrap(failErr, "unable to fail payout after transfer error")
+		}
+		return failed, nil
+	}
+	if err != nil {
+		slog.Error("payout transfer outcome is unknown, the payout has to be resolved", "payout_id", payout.ID, "err", err)
+		return s.recordTransferError(ctx, audit, payout.ID, err.Error())
+	}
+
+	sent, err := s.payoutsRepository.MarkSent(ctx, audit, payout.ID, signature)
+	if err != nil {
+		// The tokens have been sent, so the payout must not be refunded. It
+		// stays approved with the signature noted for resolving it.
+		slog.Error("unable to mark payout as sent", "payout_id", payout.ID, "signature", signature, "err", err)
+		return s.recordTransferError(ctx, audit, payout.ID, fmt.Sprintf("sent with signature %s, but unable to mark as sent: %s", signature, err))
+	}
+	slog.Info("payout has been sent", "payout_id", payout.ID, "signature", signature)
+	return sent, nil
+}
+
+func (s *Service) recordTransferError(ctx context.Context, audit *repositories.PayoutAudit, payoutID, transferError string) (*repositories.Payout, error) {
+	unresolved, err := s.payoutsRepository.RecordTransferError(ctx, audit, payoutID, transferError)
+	if err != nil {
+		slog.Error("unable to record transfer error", "payout_id", payoutID, "transfer_error", transferError, "err", err)
+		return nil, errors.Wrap(err, "unable to record transfer error")
+	}
+	return unresolved, nil
+}
+
+// Resolve settles an approved payout whose transfer outcome is unknown, after
+// a transfer error or a crash during Settle. The caller has to check on-chain
+// whether the tokens arrived: if they did the payout is marked sent with the
+// transaction signature, otherwise it is failed and the credits are refunded
+// with audit.Reason shown to the user.
+func (s *Service) Resolve(ctx context.Context, audit *repositories.PayoutAudit, payoutID string, sent bool, txSignature string) (*repositories.Payout, error) {
+	if sent {
+		if txSignature == "" {
+			return nil, ErrSignatureRequired
+		}
+		payout, err := s.payoutsRepository.MarkSent(ctx, audit, payoutID, txSignature)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("payout has been resolved as sent", "payout_id", payoutID, "signature", txSignature)
+		return payout, nil
+	}
+
+	failureReason := "transfer not sent"
+	if audit.Reason != "" {
+		failureReason += ": " + audit.Reason
+	}
+	payout, err := s.payoutsRepository.MarkFailed(ctx, audit, payoutID, repositories.PayoutStatusApproved, failureReason)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("payout has been resolved as failed", "payout_id", payout.ID, "reason", audit.Reason)
+	return payout, nil
+}
+
+// Reject fails a pending payout and refunds the credits, showing audit.Reason
+// to the user.
+func (s *Service) Reject(ctx context.Context, audit *repositories.PayoutAudit, payoutID string) (*repositories.Payout, error) {
+	failureReason := "rejected"
+	if audit.Reason != "" {
+		failureReason += ": " + audit.Reason
+	}
+	payout, err := s.payoutsRepository.MarkFailed(ctx, audit, payoutID, repositories.PayoutStatusPending, failureReason)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("payout has been rejected", "payout_id", payout.ID, "reason", audit.Reason)
+	return payout, nil
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so that the
+// limits hold across instances.
+type PostgresStore struct {
+	DB *sqlx.DB
+
+	// lastSweep is the unix time of the last removal of full buckets.
+	lastSweep atomic.Int64
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	if !limit.enabled() {
+		return true, 0, nil
+	}
+	s.sweep(ctx, now)
+
+	// Mirrors take: the bucket is only advanced if it stays within a burst.
+	interval := limit.interval().Microseconds()
+	nowMicros := now.UnixMicro()
+	maxAhead := int64(limit.burst()) * interval
+	var tat int64
+	err := s.DB.QueryRowxContext(ctx, `
+		INSERT INTO rate_limit_buckets AS b (key, tat) VALUES ($1, $2 + $3)
+		ON CONFLICT (key) DO UPDATE SET tat = GREATEST(b.tat, $2) + $3
+		WHERE GREATEST(b.tat, $2) + $3 - $2 <= $4
+		RETURNING tat;
+	`, key, nowMicros, interval, maxAhead).Scan(&tat)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	err = s.DB.GetContext(ctx, &tat, "SELECT tat FROM rate_limit_buckets WHERE key = $1", key)
+	if err != nil {
+		return false, 0, err
+	}
+	ahead := max(tat, nowMicros) + interval - nowMicros - maxAhead
+	return false, time.Duration(max(ahead, 0)) * time.Microsecond, nil
+}
+
+// sweep removes full buckets at most once a minute per instance.
+func (s *PostgresStore) sweep(ctx context.Context, now time.Time) {
+	last := s.lastSweep.Load()
+	if now.Unix()-last < 60 || !s.lastSweep.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE tat < $1", now.UnixMicro())
+	if err != nil {
+		slog.Warn("unable to remove full rate limit buckets", "err", err)
+	}
+}
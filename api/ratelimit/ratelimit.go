@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limit is a token bucket that refills Requests tokens every Per and holds
+// at most Burst tokens.
+type Limit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	// Burst defaults to Requests.
+	Burst int `yaml:"burst"`
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// interval is the time it takes to refill a single token.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// RouteLimits are the limits applied per user and per client IP. A zero
+// limit disables it.
+type RouteLimits struct {
+	User Limit `yaml:"user"`
+	IP   Limit `yaml:"ip"`
+}
+
+// Rules configure the limits of the API. Routes are keyed by method and
+// path, e.g. "POST /api/v1/event", and fall back to Default.
+type Rules struct {
+	Default RouteLimits            `yaml:"default"`
+	Routes  map[string]RouteLimits `yaml:"routes"`
+}
+
+func DefaultRules() Rules {
+	return Rules{
+		Default: RouteLimits{
+			User: Limit{Requests: 120, Per: time.Minute},
+			IP:   Limit{Requests: 600, Per: time.Minute},
+		},
+		Routes: map[string]RouteLimits{
+			"POST /api/v1/event": {
+				User: Limit{Requests: 60, Per: time.Minute, Burst: 30},
+				IP:   Limit{Requests: 300, Per: time.Minute},
+			},
+			"POST /api/v1/events/batch": {
+				User: Limit{Requests: 6, Per: time.Minute, Burst: 3},
+				IP:   Limit{Requests: 30, Per: time.Minute},
+			},
+		},
+	}
+}
+
+// LoadRules reads rules from a YAML file. The default limits are kept if the
+// file doesn't set them, routes replace the default routes.
+func LoadRules(path string) (Rules, error) {
+	rules := DefaultRules()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read rate limits: %w", err)
+	}
+	rules.Routes = nil
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to parse rate limits: %w", err)
+	}
+	return rules, nil
+}
+
+// Route returns the name of the route's buckets and its limits. Routes
+// without limits of their own share the "default" buckets.
+func (r Rules) Route(method, path string) (string, RouteLimits) {
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	route := strings.ToUpper(method) + " " + strings.ToLower(path)
+	if limits, ok := r.Routes[route]; ok {
+		return route, limits
+	}
+	return "default", r.Default
+}
+
+// Store keeps the state of the buckets. Take removes a token from the bucket
+// with the key. If none is left it returns false and the time until the next
+// token is available.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+// Limiter applies Rules using a Store.
+type Limiter struct {
+	Store Store
+	Rules Rules
+	// Now is the clock of the buckets, time.Now unless replaced in tests.
+	Now func() time.Time
+}
+
+func NewLimiter(store Store, rules Rules) *Limiter {
+	return &Limiter{Store: store, Rules: rules, Now: time.Now}
+}
+
+// Scopes of the limits of a route.
+const (
+	ScopeUser = "user"
+	ScopeIP   = "ip"
+)
+
+// Take takes a token from the bucket of the subject, a user id or client IP,
+// for the request. It returns false and the time until a token is available
+// if the limit has been exceeded.
+func (l *Limiter) Take(ctx context.Context, scope, subject, method, path string) (bool, time.Duration, error) {
+	route, limits := l.Rules.Route(method, path)
+	limit := limits.User
+	if scope == ScopeIP {
+		limit = limits.IP
+	}
+	return l.Store.Take(ctx, scope+":"+subject+":"+route, limit, l.Now())
+}
+
+// Buckets are stored as the theoretical arrival time of the next request
+// (GCRA), which behaves like a token bucket but needs a single value per key.
+// A request is allowed if the bucket doesn't run more than a full burst
+// ahead of now.
+func take(tat time.Time, limit Limit, now time.Time) (time.Time, bool, time.Duration) {
+	interval := limit.interval()
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	if ahead := next.Sub(now) - time.Duration(limit.burst())*interval; ahead > 0 {
+		return tat, false, ahead
+	}
+	return next, true, 0
+}
+
+// MemoryStore keeps buckets in process memory. Limits aren't shared between
+// instances, so it is meant for development and single instance deployments.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	if !limit.enabled() {
+		return true, 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	tat, ok, retryAfter := take(s.buckets[key], limit, now)
+	s.buckets[key] = tat
+	return ok, retryAfter, nil
+}
+
+// sweep removes buckets that are full again, since they are equivalent to
+// missing ones.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, tat := range s.buckets {
+		if !tat.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// step takes a token after the time since the start and expects the result.
+type step struct {
+	after      time.Duration
+	allowed    bool
+	retryAfter time.Duration
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	// A token every second, three at once.
+	limit := Limit{Requests: 60, Per: time.Minute, Burst: 3}
+
+	tests := map[string]struct {
+		limit Limit
+		steps []step
+	}{
+		"burst": {
+			limit: limit,
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, time.Second},
+				{0, false, time.Second},
+			},
+		},
+		"refill": {
+			limit: limit,
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, true, 0},
+				{500 * time.Millisecond, false, 500 * time.Millisecond},
+				{time.Second, true, 0},
+				{time.Second, false, time.Second},
+				{2500 * time.Millisecond, true, 0},
+				{2500 * time.Millisecond, false, 500 * time.Millisecond},
+			},
+		},
+		"full again": {
+			limit: limit,
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, true, 0},
+				{time.Hour, true, 0},
+				{time.Hour, true, 0},
+				{time.Hour, true, 0},
+				{time.Hour, false, time.Second},
+			},
+		},
+		"burst defaults to requests": {
+			limit: Limit{Requests: 2, Per: time.Minute},
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, 30 * time.Second},
+				{10 * time.Second, false, 20 * time.Second},
+				{30 * time.Second, true, 0},
+			},
+		},
+		"disabled": {
+			limit: Limit{},
+			steps: []step{
+				{0, true, 0},
+				{0, true, 0},
+				{0, true, 0},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := NewMemoryStore()
+			start := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+			for i, s := range tt.steps {
+				allowed, retryAfter, err := store.Take(context.Background(), "user:1:default", tt.limit, start.Add(s.after))
+				if err != nil {
+					t.Fatalf("step %d: unexpected error %v", i, err)
+				}
+				if allowed != s.allowed || retryAfter != s.retryAfter {
+					t.Errorf("step %d: expected allowed %v and retry after %s, got %v and %s", i, s.allowed, s.retryAfter, allowed, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeysAreIndependent(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 1, Per: time.Minute}
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	if ok, _, _ := store.Take(context.Background(), "a", limit, now); !ok {
+		t.Fatal("expected the first token of a")
+	}
+	if ok, _, _ := store.Take(context.Background(), "b", limit, now); !ok {
+		t.Error("expected the bucket of b to be full")
+	}
+	if ok, _, _ := store.Take(context.Background(), "a", limit, now); ok {
+		t.Error("expected the bucket of a to be empty")
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 1, Per: time.Second}
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	store.Take(context.Background(), "a", limit, now)
+	store.Take(context.Background(), "b", limit, now.Add(2*time.Minute))
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("expected the full bucket of a to be removed")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("expected the bucket of b to be kept")
+	}
+}
+
+func TestRulesRoute(t *testing.T) {
+	rules := DefaultRules()
+
+	tests := map[string]struct {
+		method string
+		path   string
+		want   string
+	}{
+		"route":          {"POST", "/api/v1/event", "POST /api/v1/event"},
+		"trailing slash": {"POST", "/api/v1/event/", "POST /api/v1/event"},
+		"case":           {"post", "/API/v1/Event", "POST /api/v1/event"},
+		"other method":   {"GET", "/api/v1/event", "default"},
+		"other path":     {"GET", "/api/v1/user", "default"},
+		"root":           {"GET", "/", "default"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			route, limits := rules.Route(tt.method, tt.path)
+			if route != tt.want {
+				t.Errorf("expected route %q, got %q", tt.want, route)
+			}
+			want := rules.Default
+			if tt.want != "default" {
+				want = rules.Routes[tt.want]
+			}
+			if limits != want {
+				t.Errorf("expected limits %+v, got %+v", want, limits)
+			}
+		})
+	}
+}
+
+func TestLimiterScopes(t *testing.T) {
+	now := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore(), Rules{
+		Default: RouteLimits{
+			User: Limit{Requests: 1, Per: time.Minute},
+			IP:   Limit{Requests: 2, Per: time.Minute},
+		},
+	})
+	limiter.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if ok, _, _ := limiter.Take(ctx, ScopeUser, "1", "GET", "/api/v1/user"); !ok {
+		t.Fatal("expected the first request of the user")
+	}
+	if ok, retryAfter, _ := limiter.Take(ctx, ScopeUser, "1", "GET", "/api/v1/user"); ok || retryAfter != time.Minute {
+		t.Errorf("expected the user to wait a minute, got %v and %s", ok, retryAfter)
+	}
+	for i := range 2 {
+		if ok, _, _ := limiter.Take(ctx, ScopeIP, "1", "GET", "/api/v1/user"); !ok {
+			t.Errorf("expected request %d of the ip", i)
+		}
+	}
+	if ok, _, _ := limiter.Take(ctx, ScopeIP, "1", "GET", "/api/v1/user"); ok {
+		t.Error("expected the ip to be limited")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _, _ := limiter.Take(ctx, ScopeUser, "1", "GET", "/api/v1/user"); !ok {
+		t.Error("expected the bucket of the user to refill")
+	}
+}
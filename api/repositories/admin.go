@@ -0,0 +1,188 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/go-faster/errors"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Actions recorded in the admin audit log.
+const (
+	AuditActionListUsers         = "users.list"
+	AuditActionViewUser          = "users.view"
+	AuditActionSetRole           = "users.set_role"
+	AuditActionDisableUser       = "users.disable"
+	AuditActionEnableUser        = "users.enable"
+	AuditActionAdjustCredits     = "credits.adjust"
+	AuditActionViewEvents        = "events.view"
+	AuditActionListPayouts       = "payouts.list"
+	AuditActionSettlePayout      = "payouts.settle"
+	AuditActionRejectPayout      = "payouts.reject"
+	AuditActionResolvePayout     = "payouts.resolve"
+	AuditActionViewAuditLog      = "audit_log.view"
+	AuditActionListPointsReviews = "points_reviews.list"
+	AuditActionReleasePoints     = "points_reviews.release"
+	AuditActionRejectPoints      = "points_reviews.reject"
+)
+
+type AuditLogEntry struct {
+	ID           string         `db:"id" json:"id"`
+	AdminID      sql.NullString `db:"admin_id" json:"-"`
+	Action       string         `db:"action" json:"action"`
+	TargetUserID sql.NullString `db:"target_user_id" json:"-"`
+	Details      types.JSONText `db:"details" json:"details"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+}
+
+// NewAuditLogEntry builds an entry for the action. adminID is empty for
+// actions run from the command line, targetUserID is empty for actions that
+// don't concern a single user.
+func NewAuditLogEntry(adminID, action, targetUserID string, details map[string]any) (*AuditLogEntry, error) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogEntry{
+		AdminID:      sql.NullString{String: adminID, Valid: adminID != ""},
+		Action:       action,
+		TargetUserID: sql.NullString{String: targetUserID, Valid: targetUserID != ""},
+		Details:      data,
+	}, nil
+}
+
+// AdminRepository runs admin actions. Every action that changes data writes
+// its audit log entry in the same transaction.
+type AdminRepository struct {
+	DB *sqlx.DB
+}
+
+func NewAdminRepository(db *sqlx.DB) *AdminRepository {
+	return &AdminRepository{DB: db}
+}
+
+// Record writes an audit log entry for an action that doesn't change data.
+func (r *AdminRepository) Record(ctx context.Context, entry *AuditLogEntry) error {
+	return insertAuditLogEntry(ctx, r.DB, entry)
+}
+
+func insertAuditLogEntry(ctx context.Context, db sqlx.ExtContext, entry *AuditLogEntry) error {
+	query := `
+		INSERT INTO admin_audit_log (admin_id, action, target_user_id, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+	return db.QueryRowxContext(ctx, query, entry.AdminID, entry.Action, entry.TargetUserID, entry.Details).
+		Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// ListAuditLog returns a page of audit log entries, newest first. If
+// targetUserID is set only entries concerning that user are returned.
+func (r *AdminRepository) ListAuditLog(ctx context.Context, targetUserID string, limit, offset int) ([]AuditLogEntry, error) {
+	query := `
+		SELECT * FROM admin_audit_log
+		WHERE $1 = '' OR target_user_id::text = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3;
+	`
+	entries := []AuditLogEntry{}
+	err := r.DB.SelectContext(ctx, &entries, query, targetUserID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// AdjustCredits adds amount, which may be negative, to the user's credits.
+// A balance can't be adjusted below zero.
+func (r *AdminRepository) AdjustCredits(ctx context.Context, entry *AuditLogEntry, amount int) (*credits.Transaction, error) {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userID := entry.TargetUserID.String
+	var balance int
+	err = tx.GetContext(ctx, &balance, "SELECT credits FROM users WHERE id = $1 FOR UPDATE;", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if balance+amount < 0 {
+		return nil, ErrInsufficientCredits
+	}
+
+	transaction := &credits.Transaction{
+		UserID:         userID,
+		Amount:         amount,
+		Reason:         credits.ReasonAdminAdjustment,
+		IdempotencyKey: fmt.Sprintf("admin:%s", utils.UUIDv4()),
+	}
+	if _, err := credits.Apply(ctx, tx, transaction); err != nil {
+		return nil, err
+	}
+	if err := insertAuditLogEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
+// SetDisabled disables or re-enables the account of the entry's target user.
+func (r *AdminRepository) SetDisabled(ctx context.Context, entry *AuditLogEntry, disabled bool) (*User, error) {
+	query := `
+		UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING *;
+	`
+	return r.updateUser(ctx, entry, query, entry.TargetUserID.String, disabled)
+}
+
+// SetRole changes the role of the entry's target user.
+func (r *AdminRepository) SetRole(ctx context.Context, entry *AuditLogEntry, role string) (*User, error) {
+	query := `
+		UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING *;
+	`
+	return r.updateUser(ctx, entry, query, entry.TargetUserID.String, role)
+}
+
+func (r *AdminRepository) updateUser(ctx context.Context, entry *AuditLogEntry, query string, args ...any) (*User, error) {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.GetContext(ctx, &user, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := insertAuditLogEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,39 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/jmoiron/sqlx"
+)
+
+type CreditTransactionsRepository struct {
+	DB *sqlx.DB
+}
+
+func NewCreditTransactionsRepository(db *sqlx.DB) *CreditTransactionsRepository {
+	return &CreditTransactionsRepository{DB: db}
+}
+
+// ListByUser returns a page of the user's transactions, newest first, along
+// with the total number of transactions.
+func (r *CreditTransactionsRepository) ListByUser(ctx context.Context, userID string, limit, offset int) ([]credits.Transaction, int, error) {
+	var total int
+	err := r.DB.GetContext(ctx, &total, "SELECT COUNT(*) FROM credit_transactions WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT * FROM credit_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3;
+	`
+	transactions := []credits.Transaction{}
+	err = r.DB.SelectContext(ctx, &transactions, query, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return transactions, total, nil
+}
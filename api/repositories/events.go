@@ -1,6 +1,29 @@
 package repositories
 
-import "github.com/jmoiron/sqlx"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+type Event struct {
+	ID         string         `db:"id"`
+	MessageID  sql.NullString `db:"message_id"`
+	UserID     sql.NullString `db:"user_id"`
+	EventType  string         `db:"event_type"`
+	Website    sql.NullString `db:"website"`
+	URL        sql.NullString `db:"url"`
+	Payload    types.JSONText `db:"payload"`
+	OccurredAt sql.NullTime   `db:"occurred_at"`
+	ReceivedAt time.Time      `db:"received_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+
+	ConsentVersion sql.NullInt32 `db:"consent_version"`
+}
 
 type EventsRepository struct {
 	DB *sqlx.DB
@@ -9,3 +32,104 @@ type EventsRepository struct {
 func NewEventsRepository(db *sqlx.DB) (*EventsRepository, error) {
 	return &EventsRepository{DB: db}, nil
 }
+
+const insertEventQuery = `
+	INSERT INTO events (message_id, user_id, event_type, website, url, payload, occurred_at, received_at, consent_version)
+	VALUES (:message_id, :user_id, :event_type, :website, :url, :payload, :occurred_at, :received_at, :consent_version)
+	ON CONFLICT (message_id) DO NOTHING
+`
+
+// Insert stores a single event. Events with a message id that has already
+// been stored are ignored, which makes redelivered Pub/Sub messages harmless.
+func (r *EventsRepository) Insert(ctx context.Context, event *Event) error {
+	query, args, err := r.DB.BindNamed(insertEventQuery+" RETURNING id, created_at;", event)
+	if err != nil {
+		return err
+	}
+	err = r.DB.QueryRowxContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// InsertBatch stores all events in a single statement and returns the number
+// of rows that were actually inserted.
+func (r *EventsRepository) InsertBatch(ctx context.Context, events []*Event) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	res, err := r.DB.NamedExecContext(ctx, insertEventQuery, events)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListByUser returns the user's events received within [from, to), newest first.
+func (r *EventsRepository) ListByUser(ctx context.Context, userID string, from, to time.Time, limit, offset int) ([]Event, error) {
+	query := `
+		SELECT * FROM events
+		WHERE user_id = $1 AND received_at >= $2 AND received_at < $3
+		ORDER BY received_at DESC
+		LIMIT $4 OFFSET $5;
+	`
+	events := []Event{}
+	err := r.DB.SelectContext(ctx, &events, query, userID, from, to, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CountByUser counts the user's events received within [from, to).
+func (r *EventsRepository) CountByUser(ctx context.Context, userID string, from, to time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM events
+		WHERE user_id = $1 AND received_at >= $2 AND received_at < $3;
+	`
+	var count int
+	err := r.DB.GetContext(ctx, &count, query, userID, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ListRecent returns the most recently received events, newest first. If
+// userID is set only that user's events are returned.
+func (r *EventsRepository) ListRecent(ctx context.Context, userID string, limit, offset int) ([]Event, error) {
+	query := `
+		SELECT * FROM events
+		WHERE $1 = '' OR user_id::text = $1
+		ORDER BY received_at DESC, id DESC
+		LIMIT $2 OFFSET $3;
+	`
+	events := []Event{}
+	err := r.DB.SelectContext(ctx, &events, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// EachByUser calls fn for every event of the user, oldest first, without
+// loading them all into memory.
+func (r *EventsRepository) EachByUser(ctx context.Context, userID string, fn func(*Event) error) error {
+	rows, err := r.DB.QueryxContext(ctx, "SELECT * FROM events WHERE user_id = $1 ORDER BY received_at, id;", userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event Event
+		if err := rows.StructScan(&event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
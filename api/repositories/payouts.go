@@ -0,0 +1,263 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	PayoutStatusPending  = "pending"
+	PayoutStatusApproved = "approved"
+	PayoutStatusSent     = "sent"
+	PayoutStatusFailed   = "failed"
+)
+
+var (
+	ErrPayoutNotFound       = errors.New("payout not found")
+	ErrInsufficientCredits  = errors.New("insufficient credits")
+	ErrPublicKeyNotVerified = errors.New("a verified public key is required for payouts")
+	ErrDeletionPending      = errors.New("account is scheduled for deletion")
+)
+
+type Payout struct {
+	ID            string  `db:"id" json:"id"`
+	UserID        string  `db:"user_id" json:"user_id"`
+	Amount        int     `db:"amount" json:"amount"`
+	PublicKey     string  `db:"public_key" json:"public_key"`
+	Status        string  `db:"status" json:"status"`
+	TxSignature   *string `db:"tx_signature" json:"tx_signature,omitempty"`
+	FailureReason *string `db:"failure_reason" json:"failure_reason,omitempty"`
+	// TransferError is set on approved payouts whose transfer outcome is
+	// unknown and has to be resolved manually.
+	TransferError *string   `db:"transfer_error" json:"transfer_error,omitempty"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type PayoutsRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPayoutsRepository(db *sqlx.DB) *PayoutsRepository {
+	return &PayoutsRepository{DB: db}
+}
+
+// Create stores a pending payout to the user's verified public key and
+// reserves its amount by debiting the user's credits in the same transaction.
+// The user row is locked while the balance and the key are checked, so
+// concurrent requests can't spend the same credits and the payout always goes
+// to the key that is verified at the time it is created.
+func (r *PayoutsRepository) Create(ctx context.Context, payout *Payout) error {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.GetContext(ctx, &user, `
+		SELECT id, credits, public_key, public_key_verified_at, deletion_requested_at
+		FROM users WHERE id = $1
+		FOR UPDATE;
+	`, payout.UserID)
+	if err != nil {
+		return err
+	}
+	if user.IsDeletionPending() {
+		return ErrDeletionPending
+	}
+	if !user.HasVerifiedPublicKey() {
+		return ErrPublicKeyNotVerified
+	}
+	if user.Credits < payout.Amount {
+		return ErrInsufficientCredits
+	}
+	payout.PublicKey = user.PublicKey.String
+
+	err = tx.GetContext(ctx, payout, `
+		INSERT INTO payouts (user_id, amount, public_key, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING *;
+	`, payout.UserID, payout.Amount, payout.PublicKey, PayoutStatusPending)
+	if err != nil {
+		return err
+	}
+
+	_, err = credits.Apply(ctx, tx, &credits.Transaction{
+		UserID:         payout.UserID,
+		Amount:         -payout.Amount,
+		Reason:         credits.ReasonPayoutReserve,
+		IdempotencyKey: fmt.Sprintf("payout:%s:reserve", payout.ID),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PayoutsRepository) FindByID(ctx context.Context, id string) (*Payout, error) {
+	var payout Payout
+	err := r.DB.GetContext(ctx, &payout, "SELECT * FROM payouts WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPayoutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// ListByUser returns a page of the user's payouts, newest first.
+func (r *PayoutsRepository) ListByUser(ctx context.Context, userID string, limit, offset int) ([]Payout, error) {
+	query := `
+		SELECT * FROM payouts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3;
+	`
+	payouts := []Payout{}
+	err := r.DB.SelectContext(ctx, &payouts, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// ListByStatus returns a page of payouts in the status, oldest first.
+func (r *PayoutsRepository) ListByStatus(ctx context.Context, status string, limit, offset int) ([]Payout, error) {
+	query := `
+		SELECT * FROM payouts
+		WHERE status = $1
+		ORDER BY created_at ASC
+		LIMIT $2 OFFSET $3;
+	`
+	payouts := []Payout{}
+	err := r.DB.SelectContext(ctx, &payouts, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// PayoutAudit describes on whose behalf a payout is changed by an admin
+// action. Every change is recorded in the admin audit log in the same
+// transaction, including the failure of a transfer.
+type PayoutAudit struct {
+	// AdminID is empty for commands run from the command line.
+	AdminID string
+	Action  string
+	// Reason is the reason the admin gave, if any.
+	Reason string
+}
+
+func (a *PayoutAudit) entry(payout *Payout) (*AuditLogEntry, error) {
+	details := map[string]any{
+		"payout_id": payout.ID,
+		"status":    payout.Status,
+	}
+	if a.Reason != "" {
+		details["reason"] = a.Reason
+	}
+	if payout.TxSignature != nil {
+		details["tx_signature"] = *payout.TxSignature
+	}
+	if payout.FailureReason != nil {
+		details["failure_reason"] = *payout.FailureReason
+	}
+	if payout.TransferError != nil {
+		details["transfer_error"] = *payout.TransferError
+	}
+	return NewAuditLogEntry(a.AdminID, a.Action, payout.UserID, details)
+}
+
+// update changes a single payout with an UPDATE ... RETURNING * query and
+// audits the change in the same transaction. If then is set it runs in the
+// transaction as well, before the audit entry is written.
+func (r *PayoutsRepository) update(ctx context.Context, audit *PayoutAudit, then func(tx *sqlx.Tx, payout *Payout) error, query string, args ...any) (*Payout, error) {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var payout Payout
+	err = tx.GetContext(ctx, &payout, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPayoutNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if then != nil {
+		if err := then(tx, &payout); err != nil {
+			return nil, err
+		}
+	}
+
+	entry, err := audit.entry(&payout)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertAuditLogEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// Transition moves the payout from one status to another and returns the
+// updated payout. It fails with ErrPayoutNotFound if the payout isn't in
+// the expected status anymore.
+func (r *PayoutsRepository) Transition(ctx context.Context, audit *PayoutAudit, id, from, to string) (*Payout, error) {
+	return r.update(ctx, audit, nil, `
+		UPDATE payouts SET status = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2
+		RETURNING *;
+	`, id, from, to)
+}
+
+// MarkSent records the on-chain transaction of an approved payout.
+func (r *PayoutsRepository) MarkSent(ctx context.Context, audit *PayoutAudit, id, txSignature string) (*Payout, error) {
+	return r.update(ctx, audit, nil, `
+		UPDATE payouts SET status = $2, tx_signature = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $4
+		RETURNING *;
+	`, id, PayoutStatusSent, txSignature, PayoutStatusApproved)
+}
+
+// RecordTransferError notes the error of a transfer whose outcome is unknown
+// on an approved payout. The payout stays approved until it is resolved.
+func (r *PayoutsRepository) RecordTransferError(ctx context.Context, audit *PayoutAudit, id, transferError string) (*Payout, error) {
+	return r.update(ctx, audit, nil, `
+		UPDATE payouts SET transfer_error = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2
+		RETURNING *;
+	`, id, PayoutStatusApproved, transferError)
+}
+
+// MarkFailed fails a payout that is in the from status and refunds the
+// reserved credits in the same transaction.
+func (r *PayoutsRepository) MarkFailed(ctx context.Context, audit *PayoutAudit, id, from, reason string) (*Payout, error) {
+	refund := func(tx *sqlx.Tx, payout *Payout) error {
+		_, err := credits.Apply(ctx, tx, &credits.Transaction{
+			UserID:         payout.UserID,
+			Amount:         payout.Amount,
+			Reason:         credits.ReasonPayoutRefund,
+			IdempotencyKey: fmt.Sprintf("payout:%s:refund", payout.ID),
+		})
+		return err
+	}
+	return r.update(ctx, audit, refund, `
+		UPDATE payouts SET status = $3, failure_reason = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2
+		RETURNING *;
+	`, id, from, PayoutStatusFailed, reason)
+}
@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Review statuses of points withheld by the points job.
+const (
+	PointsReviewPending  = "pending"
+	PointsReviewReleased = "released"
+	PointsReviewRejected = "rejected"
+)
+
+var (
+	ErrPointsReviewNotFound   = errors.New("points review not found")
+	ErrPointsReviewNotPending = errors.New("points review is not pending")
+)
+
+// PointsLedgerEntry is the outcome of scoring a user's events in a window.
+// Entries with a review status have been withheld because of their fraud
+// signals.
+type PointsLedgerEntry struct {
+	ID               string         `db:"id" json:"id"`
+	RunID            string         `db:"run_id" json:"run_id"`
+	UserID           string         `db:"user_id" json:"user_id"`
+	WindowStart      time.Time      `db:"window_start" json:"window_start"`
+	WindowEnd        time.Time      `db:"window_end" json:"window_end"`
+	Points           int            `db:"points" json:"points"`
+	Events           int            `db:"events" json:"events"`
+	UniqueSites      int            `db:"unique_sites" json:"unique_sites"`
+	TimeSpentSeconds int            `db:"time_spent_seconds" json:"time_spent_seconds"`
+	Capped           bool           `db:"capped" json:"capped"`
+	FraudScore       int            `db:"fraud_score" json:"fraud_score"`
+	FraudSignals     types.JSONText `db:"fraud_signals" json:"fraud_signals"`
+	ReviewStatus     *string        `db:"review_status" json:"review_status,omitempty"`
+	ReviewedBy       *string        `db:"reviewed_by" json:"reviewed_by,omitempty"`
+	ReviewedAt       *time.Time     `db:"reviewed_at" json:"reviewed_at,omitempty"`
+	CreatedAt        time.Time      `db:"created_at" json:"created_at"`
+}
+
+// ListPointsReviews returns a page of withheld points with the status,
+// oldest first.
+func (r *AdminRepository) ListPointsReviews(ctx context.Context, status string, limit, offset int) ([]PointsLedgerEntry, error) {
+	query := `
+		SELECT * FROM points_ledger
+		WHERE review_status = $1
+		ORDER BY created_at, id
+		LIMIT $2 OFFSET $3;
+	`
+	entries := []PointsLedgerEntry{}
+	err := r.DB.SelectContext(ctx, &entries, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReviewPoints releases or rejects withheld points. Released points are
+// credited with the key the points job would have used, so they can't be
+// paid twice. The entry's target user is set to the owner of the points.
+func (r *AdminRepository) ReviewPoints(ctx context.Context, entry *AuditLogEntry, ledgerID string, release bool) (*PointsLedgerEntry, error) {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var ledgerEntry PointsLedgerEntry
+	err = tx.GetContext(ctx, &ledgerEntry, "SELECT * FROM points_ledger WHERE id = $1 FOR UPDATE;", ledgerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPointsReviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ledgerEntry.ReviewStatus == nil || *ledgerEntry.ReviewStatus != PointsReviewPending {
+		return nil, ErrPointsReviewNotPending
+	}
+
+	status := PointsReviewRejected
+	if release {
+		status = PointsReviewReleased
+	}
+	err = tx.GetContext(ctx, &ledgerEntry, `
+		UPDATE points_ledger SET review_status = $2, reviewed_by = $3, reviewed_at = NOW()
+		WHERE id = $1
+		RETURNING *;
+	`, ledgerID, status, entry.AdminID)
+	if err != nil {
+		return nil, err
+	}
+
+	if release && ledgerEntry.Points > 0 {
+		_, err := credits.Apply(ctx, tx, &credits.Transaction{
+			UserID:         ledgerEntry.UserID,
+			Amount:         ledgerEntry.Points,
+			Reason:         credits.ReasonPoints,
+			SourceJobRun:   sql.NullString{String: ledgerEntry.RunID, Valid: true},
+			IdempotencyKey: credits.PointsIdempotencyKey(ledgerEntry.UserID, ledgerEntry.WindowStart, ledgerEntry.WindowEnd),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry.TargetUserID = sql.NullString{String: ledgerEntry.UserID, Valid: true}
+	if err := insertAuditLogEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &ledgerEntry, nil
+}
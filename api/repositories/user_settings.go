@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type UserSettings struct {
+	UserID          string         `db:"user_id"`
+	ConsentVersion  int            `db:"consent_version"`
+	ConsentedAt     sql.NullTime   `db:"consented_at"`
+	Categories      pq.StringArray `db:"categories"`
+	ExcludedDomains pq.StringArray `db:"excluded_domains"`
+	PauseUntil      sql.NullTime   `db:"pause_until"`
+	CreatedAt       time.Time      `db:"created_at"`
+	UpdatedAt       time.Time      `db:"updated_at"`
+}
+
+// IsSaved reports whether the user ever saved settings, as opposed to the
+// empty settings Get returns for users without any.
+func (s *UserSettings) IsSaved() bool {
+	return !s.CreatedAt.IsZero()
+}
+
+type UserSettingsRepository struct {
+	DB *sqlx.DB
+}
+
+func NewUserSettingsRepository(db *sqlx.DB) *UserSettingsRepository {
+	return &UserSettingsRepository{DB: db}
+}
+
+// Get returns the user's settings. Users that never saved settings get empty
+// settings without any consent.
+func (r *UserSettingsRepository) Get(ctx context.Context, userID string) (*UserSettings, error) {
+	var settings UserSettings
+	err := r.DB.GetContext(ctx, &settings, "SELECT * FROM user_settings WHERE user_id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &UserSettings{
+			UserID:          userID,
+			Categories:      pq.StringArray{},
+			ExcludedDomains: pq.StringArray{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// Save stores the settings. consented_at is only moved when the consent
+// version changes.
+func (r *UserSettingsRepository) Save(ctx context.Context, settings *UserSettings) error {
+	query := `
+		INSERT INTO user_settings (user_id, consent_version, consented_at, categories, excluded_domains, pause_until)
+		VALUES ($1, $2, CASE WHEN $2 > 0 THEN NOW() END, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET consent_version = EXCLUDED.consent_version,
+			consented_at = CASE
+				WHEN user_settings.consent_version = EXCLUDED.consent_version THEN user_settings.consented_at
+				ELSE EXCLUDED.consented_at
+			END,
+			categories = EXCLUDED.categories,
+			excluded_domains = EXCLUDED.excluded_domains,
+			pause_until = EXCLUDED.pause_until,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING *;
+	`
+	return r.DB.GetContext(ctx, settings, query,
+		settings.UserID, settings.ConsentVersion, settings.Categories, settings.ExcludedDomains, settings.PauseUntil)
+}
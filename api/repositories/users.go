@@ -1,11 +1,27 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"strings"
+	"time"
 
+	"github.com/go-faster/errors"
 	"github.com/jmoiron/sqlx"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrDeletionNotPending = errors.New("no deletion is pending or the grace period is over")
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 type User struct {
 	ID        string         `db:"id"`
 	Email     string         `db:"email"`
@@ -14,6 +30,31 @@ type User struct {
 	OAuthID   string         `db:"oauth_id"`
 	CreatedAt string         `db:"created_at"`
 	UpdatedAt string         `db:"updated_at"`
+
+	PublicKeyVerifiedAt sql.NullTime `db:"public_key_verified_at"`
+	Role                string       `db:"role"`
+	DisabledAt          sql.NullTime `db:"disabled_at"`
+	DeletionRequestedAt sql.NullTime `db:"deletion_requested_at"`
+	PurgeAfter          sql.NullTime `db:"purge_after"`
+	RateLimitViolations int          `db:"rate_limit_violations"`
+	RateLimitFlaggedAt  sql.NullTime `db:"rate_limit_flagged_at"`
+}
+
+// HasVerifiedPublicKey reports whether the user proved ownership of the
+// stored public key. Only verified keys are eligible for payouts.
+func (u *User) HasVerifiedPublicKey() bool {
+	return u.PublicKey.Valid && u.PublicKey.String != "" && u.PublicKeyVerifiedAt.Valid
+}
+
+// IsDisabled reports whether an admin disabled the account.
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt.Valid
+}
+
+// IsDeletionPending reports whether the user requested the deletion of the
+// account. Its data is purged once PurgeAfter has passed.
+func (u *User) IsDeletionPending() bool {
+	return u.DeletionRequestedAt.Valid
 }
 
 type UsersRepository struct {
@@ -24,34 +65,161 @@ func NewUsersRepository(db *sqlx.DB) *UsersRepository {
 	return &UsersRepository{DB: db}
 }
 
-func (r *UsersRepository) FindByEmail(email string) (*User, error) {
+// FindByEmail returns the user with the email. Emails aren't unique, since
+// identity is keyed on the OAuth subject, so it fails if several users share
+// the email.
+func (r *UsersRepository) FindByEmail(ctx context.Context, email string) (*User, error) {
+	users := []User{}
+	err := r.DB.SelectContext(ctx, &users, "SELECT * FROM users WHERE email = $1 LIMIT 2", email)
+	if err != nil {
+		return nil, err
+	}
+	switch len(users) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return &users[0], nil
+	default:
+		return nil, errors.Errorf("multiple users have the email %s", email)
+	}
+}
+
+func (r *UsersRepository) FindByOAuthID(ctx context.Context, oauthID string) (*User, error) {
 	var user User
-	err := r.DB.Get(&user, "SELECT * FROM users WHERE email = $1", email)
+	err := r.DB.GetContext(ctx, &user, "SELECT * FROM users WHERE oauth_id = $1", oauthID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UsersRepository) Create(user *User) error {
+// UpsertByOAuthID creates the user with the OAuth subject or, if it already
+// exists, updates its email. It is atomic, so concurrent first requests of a
+// new user can't create duplicates. The returned bool reports whether the user
+// has been created.
+func (r *UsersRepository) UpsertByOAuthID(ctx context.Context, oauthID, email string) (*User, bool, error) {
 	query := `
 		INSERT INTO users (email, oauth_id, created_at, updated_at)
-		VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+		VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (oauth_id) DO UPDATE
+		SET email = EXCLUDED.email,
+			updated_at = CASE WHEN users.email = EXCLUDED.email THEN users.updated_at ELSE CURRENT_TIMESTAMP END
+		RETURNING *, (xmax = 0) AS inserted;
 	`
-	_, err := r.DB.Exec(query, user.Email, user.OAuthID)
+	var row struct {
+		User
+		Inserted bool `db:"inserted"`
+	}
+	err := r.DB.GetContext(ctx, &row, query, email, oauthID)
+	if err != nil {
+		return nil, false, err
+	}
+	return &row.User, row.Inserted, nil
+}
+
+func (r *UsersRepository) FindByID(ctx context.Context, id string) (*User, error) {
+	var user User
+	err := r.DB.GetContext(ctx, &user, "SELECT * FROM users WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return &user, nil
 }
 
-func (r *UsersRepository) UpdatePublicKey(id string, publicKey string) error {
+// Search returns a page of users whose email contains the query, or whose id
+// equals it, newest first, along with the total number of matches. An empty
+// query matches all users.
+func (r *UsersRepository) Search(ctx context.Context, q string, limit, offset int) ([]User, int, error) {
+	where := `$1 = '' OR email ILIKE $2 OR id::text = $1`
+	pattern := "%" + likeEscaper.Replace(q) + "%"
+
+	var total int
+	err := r.DB.GetContext(ctx, &total, "SELECT COUNT(*) FROM users WHERE "+where, q, pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT * FROM users
+		WHERE ` + where + `
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4;
+	`
+	users := []User{}
+	err = r.DB.SelectContext(ctx, &users, query, q, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// RequestDeletion soft-deletes the user. The data is purged after purgeAfter
+// unless the request is cancelled before. Requesting it again keeps the
+// original schedule.
+func (r *UsersRepository) RequestDeletion(ctx context.Context, id string, purgeAfter time.Time) (*User, error) {
+	query := `
+		UPDATE users
+		SET deletion_requested_at = COALESCE(deletion_requested_at, NOW()),
+			purge_after = COALESCE(purge_after, $2),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING *;
+	`
+	var user User
+	err := r.DB.GetContext(ctx, &user, query, id, purgeAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CancelDeletion restores a soft-deleted user. It fails with
+// ErrDeletionNotPending once the purge may have started.
+func (r *UsersRepository) CancelDeletion(ctx context.Context, id string) (*User, error) {
 	query := `
-		UPDATE users SET public_key = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2;
+		UPDATE users
+		SET deletion_requested_at = NULL, purge_after = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deletion_requested_at IS NOT NULL AND purge_after > NOW()
+		RETURNING *;
 	`
-	_, err := r.DB.Exec(query, publicKey, id)
+	var user User
+	err := r.DB.GetContext(ctx, &user, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeletionNotPending
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RecordRateLimitViolation counts a rate limit violation of the user and
+// flags the user once the violations reach the threshold. It reports whether
+// the user has just been flagged.
+func (r *UsersRepository) RecordRateLimitViolation(ctx context.Context, id string, threshold int) (bool, error) {
+	query := `
+		UPDATE users
+		SET rate_limit_violations = rate_limit_violations + 1,
+			rate_limit_flagged_at = CASE
+				WHEN rate_limit_flagged_at IS NULL AND rate_limit_violations + 1 >= $2 THEN NOW()
+				ELSE rate_limit_flagged_at
+			END
+		WHERE id = $1
+		RETURNING rate_limit_flagged_at IS NOT NULL AND rate_limit_violations = $2;
+	`
+	var flagged bool
+	err := r.DB.GetContext(ctx, &flagged, query, id, threshold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
 	}
-	return nil
+	return flagged, err
 }
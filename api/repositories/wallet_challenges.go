@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrChallengeNotFound = errors.New("challenge not found")
+
+type WalletChallenge struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	PublicKey string       `db:"public_key"`
+	Nonce     string       `db:"nonce"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type WalletChallengesRepository struct {
+	DB *sqlx.DB
+}
+
+func NewWalletChallengesRepository(db *sqlx.DB) *WalletChallengesRepository {
+	return &WalletChallengesRepository{DB: db}
+}
+
+func (r *WalletChallengesRepository) Create(ctx context.Context, challenge *WalletChallenge) error {
+	query := `
+		INSERT INTO wallet_challenges (user_id, public_key, nonce, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+	return r.DB.QueryRowxContext(ctx, query, challenge.UserID, challenge.PublicKey, challenge.Nonce, challenge.ExpiresAt).
+		Scan(&challenge.ID, &challenge.CreatedAt)
+}
+
+// FindActive returns the user's unused and unexpired challenge with the nonce.
+func (r *WalletChallengesRepository) FindActive(ctx context.Context, userID, nonce string) (*WalletChallenge, error) {
+	var challenge WalletChallenge
+	query := `
+		SELECT * FROM wallet_challenges
+		WHERE user_id = $1 AND nonce = $2 AND used_at IS NULL AND expires_at > NOW();
+	`
+	err := r.DB.GetContext(ctx, &challenge, query, userID, nonce)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// Complete marks the challenge as used and stores its public key as the
+// user's verified key in one transaction, so a challenge can't be used twice.
+func (r *WalletChallengesRepository) Complete(ctx context.Context, challenge *WalletChallenge) error {
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE wallet_challenges SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW();
+	`, challenge.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrChallengeNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET public_key = $1, public_key_verified_at = NOW(), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2;
+	`, challenge.PublicKey, challenge.UserID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
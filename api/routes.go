@@ -5,21 +5,35 @@ import (
 	"github.com/devs-group/driplet/api/di"
 	"github.com/devs-group/driplet/api/handlers"
 	"github.com/devs-group/driplet/api/middlewares"
+	"github.com/devs-group/driplet/api/ratelimit"
 	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/pkg/config"
 	"github.com/devs-group/godi"
 	"github.com/go-faster/errors"
 	"github.com/gofiber/fiber/v2"
 )
 
 func InitRoutes(app *fiber.App) error {
+	cfg, err := godi.Resolve[*config.Config](di.Container)
+	if err != nil {
+		return errors.Wrap(err, "unable to resolve config")
+	}
 	tokenValidator, err := godi.Resolve[*auth.TokenValidator](di.Container)
 	if err != nil {
 		return errors.Wrap(err, "unable to resolve token validator")
 	}
+	tokenCache, err := godi.Resolve[*auth.TokenCache](di.Container)
+	if err != nil {
+		return errors.Wrap(err, "unable to resolve token cache")
+	}
 	userRepository, err := godi.Resolve[*repositories.UsersRepository](di.Container)
 	if err != nil {
 		return errors.Wrap(err, "unable to resolve users repository")
 	}
+	rateLimiter, err := godi.Resolve[*ratelimit.Limiter](di.Container)
+	if err != nil {
+		return errors.Wrap(err, "unable to resolve rate limiter")
+	}
 
 	usersHandler, err := handlers.NewUsersHandler()
 	if err != nil {
@@ -33,21 +47,78 @@ func InitRoutes(app *fiber.App) error {
 	if err != nil {
 		return errors.Wrap(err, "unable to create new events handler")
 	}
+	payoutsHandler, err := handlers.NewPayoutsHandler()
+	if err != nil {
+		return errors.Wrap(err, "unable to create new payouts handler")
+	}
+	settingsHandler, err := handlers.NewSettingsHandler()
+	if err != nil {
+		return errors.Wrap(err, "unable to create new settings handler")
+	}
+	adminHandler, err := handlers.NewAdminHandler()
+	if err != nil {
+		return errors.Wrap(err, "unable to create new admin handler")
+	}
+	metricsHandler, err := handlers.NewMetricsHandler()
+	if err != nil {
+		return errors.Wrap(err, "unable to create new metrics handler")
+	}
+
+	app.Use(middlewares.Metrics())
+	app.Use(middlewares.Tracing())
+	app.Use(middlewares.RequestLogger())
 
+	rateLimitConfig := middlewares.RateLimitConfig{
+		Limiter:       rateLimiter,
+		Violations:    userRepository,
+		FlagThreshold: cfg.API.RateLimitFlagThreshold,
+	}
 	v1 := app.Group(
 		"/api/v1",
+		middlewares.RateLimitByIP(rateLimitConfig),
 		middlewares.RequireAuth(middlewares.AuthConfig{
 			TokenValidator:  tokenValidator,
 			UsersRepository: userRepository,
+			TokenCache:      tokenCache,
 		}),
+		middlewares.RateLimitByUser(rateLimitConfig),
 	)
 	app.Get("/health", healthHandler.GET_health)
+	app.Get("/health/live", healthHandler.GET_Live)
+	app.Get("/health/ready", healthHandler.GET_Ready)
+	app.Get("/metrics", metricsHandler.GET_Metrics)
 	v1.Options("*", func(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusOK)
 	})
 	v1.Get("/user", usersHandler.GET_User)
+	v1.Delete("/user", usersHandler.DELETE_User)
+	v1.Post("/user/deletion/cancel", usersHandler.POST_CancelDeletion)
+	v1.Get("/user/export", usersHandler.GET_Export)
+	v1.Get("/user/settings", settingsHandler.GET_Settings)
+	v1.Put("/user/settings", settingsHandler.PUT_Settings)
+	v1.Post("/user/public-key/challenge", usersHandler.POST_PublicKeyChallenge)
 	v1.Put("/user/public-key", usersHandler.PUT_UpdateUsersPublicKey)
+	v1.Get("/user/credits/history", usersHandler.GET_CreditsHistory)
+	v1.Get("/user/payouts", payoutsHandler.GET_Payouts)
+	v1.Post("/user/payouts", payoutsHandler.POST_RequestPayout)
 	v1.Post("/event", eventsHandler.POST_CreateEvent)
+	v1.Post("/events/batch", eventsHandler.POST_CreateEventsBatch)
+
+	admin := v1.Group("/admin", middlewares.RequireRole(repositories.RoleAdmin))
+	admin.Get("/users", adminHandler.GET_Users)
+	admin.Get("/users/:id", adminHandler.GET_User)
+	admin.Post("/users/:id/credits", adminHandler.POST_AdjustCredits)
+	admin.Post("/users/:id/disable", adminHandler.POST_DisableUser)
+	admin.Post("/users/:id/enable", adminHandler.POST_EnableUser)
+	admin.Get("/events", adminHandler.GET_Events)
+	admin.Get("/payouts", adminHandler.GET_Payouts)
+	admin.Post("/payouts/:id/settle", adminHandler.POST_SettlePayout)
+	admin.Post("/payouts/:id/reject", adminHandler.POST_RejectPayout)
+	admin.Post("/payouts/:id/resolve", adminHandler.POST_ResolvePayout)
+	admin.Get("/points-reviews", adminHandler.GET_PointsReviews)
+	admin.Post("/points-reviews/:id/release", adminHandler.POST_ReleasePoints)
+	admin.Post("/points-reviews/:id/reject", adminHandler.POST_RejectPoints)
+	admin.Get("/audit-log", adminHandler.GET_AuditLog)
 
 	return nil
 }
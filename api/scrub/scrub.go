@@ -0,0 +1,302 @@
+package scrub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/devs-group/driplet/pkg/events"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules configure which personal data is removed from events before they are
+// published.
+type Rules struct {
+	// DropCookies removes the document cookies.
+	DropCookies bool `yaml:"drop_cookies"`
+	// DropFormValues removes the values of all form inputs. Password inputs
+	// are always emptied.
+	DropFormValues bool `yaml:"drop_form_values"`
+	// SensitiveParams are case-insensitive patterns of query parameter names
+	// that are removed from URLs. Fragments holding such parameters are
+	// removed as well.
+	SensitiveParams []string `yaml:"sensitive_params"`
+	// StripFragments removes all URL fragments.
+	StripFragments bool `yaml:"strip_fragments"`
+	// HashEmails replaces email addresses in URLs and texts with a hash.
+	HashEmails bool `yaml:"hash_emails"`
+	// TokenPatterns match tokens in URLs and texts that are replaced with a
+	// hash.
+	TokenPatterns []string `yaml:"token_patterns"`
+	// DeniedDomains are domains whose events are dropped entirely, including
+	// their subdomains. Links to them are removed from other events.
+	DeniedDomains []string `yaml:"denied_domains"`
+}
+
+func DefaultRules() Rules {
+	return Rules{
+		DropCookies:    true,
+		DropFormValues: true,
+		SensitiveParams: []string{
+			`token`,
+			`^code$`,
+			`^state$`,
+			`pass(word|wd)?$`,
+			`^pwd$`,
+			`secret`,
+			`session`,
+			`^sid$`,
+			`^auth`,
+			`api[_-]?key`,
+			`^key$`,
+			`sig(nature)?$`,
+			`e-?mail`,
+		},
+		HashEmails: true,
+		TokenPatterns: []string{
+			// JSON web tokens
+			`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+			// hex encoded secrets and hashes
+			`\b[0-9a-fA-F]{32,}\b`,
+			// long opaque tokens
+			`\b[A-Za-z0-9]{40,}\b`,
+		},
+		DeniedDomains: []string{
+			// banking and payments
+			"chase.com",
+			"bankofamerica.com",
+			"wellsfargo.com",
+			"paypal.com",
+			"deutsche-bank.de",
+			"sparkasse.de",
+			"n26.com",
+			// health
+			"webmd.com",
+			"mayoclinic.org",
+			"healthline.com",
+			"patient.info",
+			"doctolib.de",
+		},
+	}
+}
+
+// LoadRules reads rules from a YAML file. Fields missing from the file keep
+// their default values, lists given in the file replace the defaults.
+func LoadRules(path string) (Rules, error) {
+	rules := DefaultRules()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read scrubbing rules: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to parse scrubbing rules: %w", err)
+	}
+	return rules, nil
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// Scrubber applies Rules to events.
+type Scrubber struct {
+	rules           Rules
+	salt            []byte
+	sensitiveParams []*regexp.Regexp
+	tokenPatterns   []*regexp.Regexp
+	deniedDomains   []string
+}
+
+// NewScrubber compiles the rules. Hashes are keyed with the salt, so that
+// they can't be reversed by hashing guessed values.
+func NewScrubber(rules Rules, salt string) (*Scrubber, error) {
+	s := &Scrubber{rules: rules, salt: []byte(salt)}
+	for _, p := range rules.SensitiveParams {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensitive param pattern %q: %w", p, err)
+		}
+		s.sensitiveParams = append(s.sensitiveParams, re)
+	}
+	for _, p := range rules.TokenPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token pattern %q: %w", p, err)
+		}
+		s.tokenPatterns = append(s.tokenPatterns, re)
+	}
+	for _, d := range rules.DeniedDomains {
+		d = strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" {
+			s.deniedDomains = append(s.deniedDomains, d)
+		}
+	}
+	return s, nil
+}
+
+// Scrub removes personal data from the event in place. It returns false if
+// the event comes from a denied domain and must be dropped.
+func (s *Scrubber) Scrub(e *events.PageEvent) bool {
+	if s.denied(e.Website) || s.deniedURL(e.URL) {
+		return false
+	}
+
+	if s.rules.DropCookies {
+		e.Cookies = ""
+	}
+	e.URL = s.scrubURL(e.URL)
+	e.Path = s.hashText(e.Path)
+	e.Title = s.hashText(e.Title)
+	if s.deniedURL(e.Referrer) {
+		e.Referrer = ""
+	} else {
+		e.Referrer = s.scrubURL(e.Referrer)
+	}
+
+	links := e.Links[:0]
+	for _, link := range e.Links {
+		if s.deniedURL(link) {
+			continue
+		}
+		links = append(links, s.scrubURL(link))
+	}
+	e.Links = links
+
+	for i := range e.Images {
+		e.Images[i].Src = s.scrubURL(e.Images[i].Src)
+		e.Images[i].Alt = s.hashText(e.Images[i].Alt)
+	}
+	for i := range e.Forms {
+		form := &e.Forms[i]
+		form.Action = s.scrubURL(form.Action)
+		for j := range form.Inputs {
+			if s.rules.DropFormValues || strings.EqualFold(form.Inputs[j].Type, "password") {
+				form.Inputs[j].Value = nil
+			}
+		}
+	}
+	return true
+}
+
+func (s *Scrubber) denied(host string) bool {
+	host = strings.Trim(strings.ToLower(host), ".")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return false
+	}
+	for _, d := range s.deniedDomains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scrubber) deniedURL(raw string) bool {
+	if raw == "" || len(s.deniedDomains) == 0 {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return s.denied(u.Host)
+}
+
+// scrubURL removes credentials, sensitive query parameters and fragments
+// from the URL and hashes emails and tokens in the rest of it. URLs that
+// can't be parsed lose their query and fragment.
+func (s *Scrubber) scrubURL(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		if i := strings.IndexAny(raw, "?#"); i >= 0 {
+			raw = raw[:i]
+		}
+		return s.hashText(raw)
+	}
+
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = s.scrubQuery(u.Query()).Encode()
+	}
+	if u.Fragment != "" {
+		if s.rules.StripFragments || s.sensitiveFragment(u.Fragment) {
+			u.Fragment = ""
+		} else {
+			u.Fragment = s.hashText(u.Fragment)
+		}
+		u.RawFragment = ""
+	}
+	u.Path = s.hashText(u.Path)
+	u.RawPath = ""
+	return u.String()
+}
+
+func (s *Scrubber) scrubQuery(query url.Values) url.Values {
+	for name, values := range query {
+		if s.sensitiveParam(name) {
+			query.Del(name)
+			continue
+		}
+		for i, v := range values {
+			values[i] = s.hashText(v)
+		}
+	}
+	return query
+}
+
+func (s *Scrubber) sensitiveParam(name string) bool {
+	for _, re := range s.sensitiveParams {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveFragment reports whether the fragment carries sensitive
+// parameters, as OAuth implicit flows do with #access_token=...
+func (s *Scrubber) sensitiveFragment(fragment string) bool {
+	if !strings.Contains(fragment, "=") {
+		return false
+	}
+	params, err := url.ParseQuery(strings.TrimPrefix(fragment, "/"))
+	if err != nil {
+		return true
+	}
+	for name := range params {
+		if s.sensitiveParam(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashText replaces emails and tokens in the text with a keyed hash.
+func (s *Scrubber) hashText(text string) string {
+	if text == "" {
+		return text
+	}
+	if s.rules.HashEmails {
+		text = emailPattern.ReplaceAllStringFunc(text, s.hash)
+	}
+	for _, re := range s.tokenPatterns {
+		text = re.ReplaceAllStringFunc(text, s.hash)
+	}
+	return text
+}
+
+func (s *Scrubber) hash(value string) string {
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(value))
+	return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
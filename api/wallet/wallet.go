@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/mr-tron/base58"
+)
+
+// KeyTypeSolana is an ed25519 public key encoded as base58, the address
+// format of Solana wallets.
+const KeyTypeSolana = "solana"
+
+// SupportedKeyTypes lists the wallet key types payouts can be sent to.
+var SupportedKeyTypes = []string{KeyTypeSolana}
+
+// ChallengeTTL is how long a user has to sign an issued challenge.
+const ChallengeTTL = 10 * time.Minute
+
+var (
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+	ErrInvalidPublicKey   = errors.New("invalid public key")
+	ErrInvalidSignature   = errors.New("invalid signature")
+)
+
+// ParsePublicKey validates the format of a wallet public key.
+func ParsePublicKey(keyType, publicKey string) (ed25519.PublicKey, error) {
+	if !slices.Contains(SupportedKeyTypes, keyType) {
+		return nil, ErrUnsupportedKeyType
+	}
+	raw, err := base58.Decode(publicKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// NewNonce returns a random challenge nonce.
+func NewNonce() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ChallengeMessage is the exact text the wallet has to sign to prove that
+// the user owns the key.
+func ChallengeMessage(userID, publicKey, nonce string, expiresAt time.Time) string {
+	return fmt.Sprintf(
+		"Driplet wallet verification\n\nSign this message to link your wallet to your Driplet account.\n\nUser: %s\nWallet: %s\nNonce: %s\nExpires: %s",
+		userID, publicKey, nonce, expiresAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// VerifySignature checks a detached ed25519 signature of message. Wallets
+// return signatures as base58 or base64, both are accepted.
+func VerifySignature(publicKey ed25519.PublicKey, message, signature string) error {
+	sig, err := base58.Decode(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		sig, err = base64.StdEncoding.DecodeString(signature)
+		if err != nil || len(sig) != ed25519.SignatureSize {
+			return ErrInvalidSignature
+		}
+	}
+	if !ed25519.Verify(publicKey, []byte(message), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package workers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"github.com/devs-group/driplet/api/repositories"
+	"github.com/devs-group/driplet/pkg/events"
+	"github.com/devs-group/driplet/pkg/pubsub"
+	"github.com/go-faster/errors"
+)
+
+const (
+	EventsSubscription    = "client-events-postgres"
+	EventsDeadLetterTopic = "client-events-dead-letter"
+)
+
+type EventsWorkerConfig struct {
+	// BatchSize is the number of events written with a single insert.
+	BatchSize int
+	// FlushInterval bounds how long an event waits for its batch to fill up.
+	FlushInterval time.Duration
+}
+
+func DefaultEventsWorkerConfig() EventsWorkerConfig {
+	return EventsWorkerConfig{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+	}
+}
+
+// EventsWorker drains the client-events topic into the events table. Messages
+// are buffered and written in batches; a message is only acked once the batch
+// containing it has been committed.
+type EventsWorker struct {
+	subscriber       *pubsub.Subscriber
+	eventsRepository *repositories.EventsRepository
+	config           EventsWorkerConfig
+	pending          chan pendingEvent
+}
+
+type pendingEvent struct {
+	event *repositories.Event
+	done  chan error
+}
+
+func NewEventsWorker(subscriber *pubsub.Subscriber, eventsRepository *repositories.EventsRepository, cfg EventsWorkerConfig) *EventsWorker {
+	return &EventsWorker{
+		subscriber:       subscriber,
+		eventsRepository: eventsRepository,
+		config:           cfg,
+		pending:          make(chan pendingEvent),
+	}
+}
+
+// Run blocks until ctx is cancelled or the subscription fails.
+func (w *EventsWorker) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		w.flushLoop(ctx)
+	}()
+
+	slog.Info("draining events into postgres", "subscription", EventsSubscription, "batch_size", w.config.BatchSize)
+	err := w.subscriber.Subscribe(ctx, w.handle)
+	cancel()
+	<-flusherDone
+	return err
+}
+
+func (w *EventsWorker) handle(ctx context.Context, msg *gpubsub.Message) error {
+	event, err := eventFromMessage(msg)
+	if err != nil {
+		// Retrying won't make a malformed message valid.
+		return pubsub.Permanent(err)
+	}
+
+	p := pendingEvent{event: event, done: make(chan error, 1)}
+	select {
+	case w.pending <- p:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := <-p.done; err != nil {
+		return errors.Wrap(err, "unable to store event")
+	}
+	return nil
+}
+
+func (w *EventsWorker) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingEvent, 0, w.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		rows := make([]*repositories.Event, len(batch))
+		for i, p := range batch {
+			rows[i] = p.event
+		}
+
+		// The batch is written even if ctx has just been cancelled so that
+		// handlers waiting on it can still ack.
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		inserted, err := w.eventsRepository.InsertBatch(flushCtx, rows)
+		if err == nil {
+			slog.Debug("events batch has been stored", "events", len(rows), "inserted", inserted)
+			for _, p := range batch {
+				p.done <- nil
+			}
+			batch = batch[:0]
+			return
+		}
+
+		// One bad row fails the whole statement, so fall back to inserting
+		// the events one by one and only reject the ones that fail.
+		slog.Warn("unable to store events batch, inserting individually", "events", len(rows), "err", err)
+		for _, p := range batch {
+			p.done <- w.eventsRepository.Insert(flushCtx, p.event)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case p := <-w.pending:
+			batch = append(batch, p)
+			if len(batch) >= w.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+func eventFromMessage(msg *gpubsub.Message) (*repositories.Event, error) {
+	envelope, err := events.Unmarshal(msg.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode event")
+	}
+	info := envelope.Data
+	if info.Event == "" {
+		return nil, errors.New("event type is missing")
+	}
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode event payload")
+	}
+
+	event := &repositories.Event{
+		MessageID:  sql.NullString{String: msg.ID, Valid: msg.ID != ""},
+		UserID:     nullString(msg.Attributes[events.AttrUserID]),
+		EventType:  info.Event,
+		Website:    nullString(info.Website),
+		URL:        nullString(info.URL),
+		Payload:    payload,
+		ReceivedAt: msg.PublishTime,
+	}
+	if t, err := info.OccurredAt(); err == nil {
+		event.OccurredAt = sql.NullTime{Time: t, Valid: true}
+	}
+	if receivedAt, ok := events.ReceivedAt(msg.Attributes); ok {
+		event.ReceivedAt = receivedAt
+	}
+	if event.ReceivedAt.IsZero() {
+		event.ReceivedAt = time.Now()
+	}
+	if v, err := strconv.Atoi(msg.Attributes[events.AttrConsentVersion]); err == nil {
+		event.ConsentVersion = sql.NullInt32{Int32: int32(v), Valid: true}
+	}
+	return event, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
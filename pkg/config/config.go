@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devs-group/driplet/pkg/db"
+	"github.com/devs-group/driplet/pkg/pubsub"
+	"github.com/devs-group/driplet/pkg/tracing"
+)
+
+// Config holds the settings of the api and the scheduler. Every setting has
+// a key in the config file and most have an environment variable, see Load.
+type Config struct {
+	API      API            `yaml:"api"`
+	Database db.Config      `yaml:"database"`
+	PubSub   pubsub.Config  `yaml:"pubsub"`
+	Tracing  tracing.Config `yaml:"tracing"`
+}
+
+type API struct {
+	Port int `yaml:"port" env:"PORT"`
+	// ProxyHeader carries the client IP when running behind a proxy.
+	ProxyHeader string `yaml:"proxy_header" env:"PROXY_HEADER"`
+	// ShutdownTimeout is the time in-flight requests get to finish on SIGTERM.
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	HealthCheckTimeout  time.Duration `yaml:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	HealthCheckCacheTTL time.Duration `yaml:"health_check_cache_ttl" env:"HEALTH_CHECK_CACHE_TTL"`
+	// MetricsToken is required as bearer token on /metrics if set.
+	MetricsToken string `yaml:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+
+	GoogleClientID            string   `yaml:"google_client_id" env:"GOOGLE_CLIENT_ID"`
+	GoogleJWKSURL             string   `yaml:"google_jwks_url" env:"GOOGLE_JWKS_URL"`
+	AllowedExtensionClientIDs []string `yaml:"allowed_extension_client_ids" env:"ALLOWED_EXTENSION_CLIENT_IDS"`
+	TokenCacheSize            int      `yaml:"token_cache_size" env:"TOKEN_CACHE_SIZE"`
+	// TokenCacheTTL bounds how long a cached user may be stale.
+	TokenCacheTTL time.Duration `yaml:"token_cache_ttl" env:"TOKEN_CACHE_TTL"`
+
+	IPHashSalt     string `yaml:"ip_hash_salt" env:"IP_HASH_SALT" secret:"true"`
+	ScrubRulesFile string `yaml:"scrub_rules_file" env:"SCRUB_RULES_FILE"`
+	ScrubHashSalt  string `yaml:"scrub_hash_salt" env:"SCRUB_HASH_SALT" secret:"true"`
+
+	// PayoutTransferer is "fake" or empty, which disables settling payouts.
+	PayoutTransferer string `yaml:"payout_transferer" env:"PAYOUT_TRANSFERER"`
+	PayoutMinAmount  int    `yaml:"payout_min_amount" env:"PAYOUT_MIN_AMOUNT"`
+
+	// RateLimitStore is "memory" or "postgres".
+	RateLimitStore         string `yaml:"rate_limit_store" env:"RATE_LIMIT_STORE"`
+	RateLimitsFile         string `yaml:"rate_limits_file" env:"RATE_LIMITS_FILE"`
+	RateLimitFlagThreshold int    `yaml:"rate_limit_flag_threshold" env:"RATE_LIMIT_FLAG_THRESHOLD"`
+
+	ConsentVersion int `yaml:"consent_version" env:"CONSENT_VERSION"`
+	// RequireConsent rejects events of users that never saved settings. It
+	// must stay off until clients record consent via PUT /api/v1/user/settings.
+	RequireConsent             bool          `yaml:"require_consent" env:"REQUIRE_CONSENT"`
+	AccountDeletionGracePeriod time.Duration `yaml:"account_deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+}
+
+func Default() Config {
+	return Config{
+		API: API{
+			Port:                       9000,
+			ShutdownTimeout:            8 * time.Second,
+			HealthCheckTimeout:         2 * time.Second,
+			HealthCheckCacheTTL:        5 * time.Second,
+			TokenCacheSize:             10000,
+			TokenCacheTTL:              30 * time.Second,
+			PayoutMinAmount:            100,
+			RateLimitStore:             "memory",
+			RateLimitFlagThreshold:     10,
+			ConsentVersion:             1,
+			AccountDeletionGracePeriod: 30 * 24 * time.Hour,
+		},
+		Database: db.DefaultConfig(),
+		PubSub:   pubsub.DefaultConfig(),
+		Tracing:  tracing.DefaultConfig(),
+	}
+}
+
+// Validate checks the values of all settings. Settings only some commands
+// need are checked by them, see ValidateServer, ValidateDatabase and
+// ValidatePubSub.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	api := c.API
+	check(api.Port > 0 && api.Port <= 65535, "PORT must be between 1 and 65535")
+	check(api.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(api.HealthCheckTimeout > 0, "HEALTH_CHECK_TIMEOUT must be positive")
+	check(api.HealthCheckCacheTTL >= 0, "HEALTH_CHECK_CACHE_TTL must not be negative")
+	check(api.TokenCacheSize > 0, "TOKEN_CACHE_SIZE must be positive")
+	check(api.TokenCacheTTL > 0, "TOKEN_CACHE_TTL must be positive")
+	check(api.PayoutTransferer == "" || api.PayoutTransferer == "fake", "PAYOUT_TRANSFERER must be empty or fake")
+	check(api.PayoutMinAmount > 0, "PAYOUT_MIN_AMOUNT must be positive")
+	check(api.RateLimitStore == "memory" || api.RateLimitStore == "postgres", "RATE_LIMIT_STORE must be memory or postgres")
+	check(api.RateLimitFlagThreshold > 0, "RATE_LIMIT_FLAG_THRESHOLD must be positive")
+	check(api.ConsentVersion > 0, "CONSENT_VERSION must be positive")
+	check(api.AccountDeletionGracePeriod >= 0, "ACCOUNT_DELETION_GRACE_PERIOD must not be negative")
+
+	database := c.Database
+	check(database.MaxOpenConns > 0, "DB_MAX_OPEN_CONNS must be positive")
+	check(database.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(database.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME must not be negative")
+	check(database.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME must not be negative")
+	check(database.MaxRetries >= 0, "DB_MAX_RETRIES must not be negative")
+	check(database.RetryDelay > 0, "DB_RETRY_DELAY must be positive")
+	check(database.RetryMaxDelay >= database.RetryDelay, "DB_RETRY_MAX_DELAY must not be below DB_RETRY_DELAY")
+
+	publisher := c.PubSub.DefaultPublisherConfig
+	check(publisher.CountThreshold > 0, "PUBSUB_PUBLISH_COUNT_THRESHOLD must be positive")
+	check(publisher.ByteThreshold > 0, "PUBSUB_PUBLISH_BYTE_THRESHOLD must be positive")
+	check(publisher.DelayThreshold > 0, "PUBSUB_PUBLISH_DELAY_THRESHOLD must be positive")
+	check(publisher.MaxOutstandingMessages >= 0, "PUBSUB_PUBLISH_MAX_OUTSTANDING_MESSAGES must not be negative")
+	check(publisher.MaxOutstandingBytes >= 0, "PUBSUB_PUBLISH_MAX_OUTSTANDING_BYTES must not be negative")
+
+	tc := c.Tracing
+	switch tc.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterFile:
+	default:
+		check(false, "TRACING_EXPORTER must be empty, otlp, stdout or file")
+	}
+	check(tc.Exporter != tracing.ExporterFile || tc.File != "", "TRACING_FILE is required with TRACING_EXPORTER=file")
+	check(tc.SampleRatio >= 0 && tc.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+// ValidateDatabase checks the settings of commands that connect to the
+// database.
+func (c *Config) ValidateDatabase() error {
+	if c.Database.ConnectionString == "" {
+		return errors.New("DATABASE_URL is required")
+	}
+	return nil
+}
+
+// ValidatePubSub checks the settings of commands that publish or consume
+// messages.
+func (c *Config) ValidatePubSub() error {
+	if c.PubSub.ProjectID == "" {
+		return errors.New("PUBSUB_PROJECT_ID is required")
+	}
+	return nil
+}
+
+// ValidateServer checks the settings the api can't serve requests without.
+func (c *API) ValidateServer() error {
+	if c.GoogleClientID == "" {
+		return errors.New("GOOGLE_CLIENT_ID is required")
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileFlag names the flag pointing at the optional config file.
+const FileFlag = "config"
+
+const redacted = "REDACTED"
+
+// setting is a leaf of the config, addressed by the dotted keys of the config
+// file.
+type setting struct {
+	path  string
+	env   string
+	value reflect.Value
+}
+
+// Flags returns the --config flag and a flag per setting, named after its
+// path in the config file, e.g. --database.max-open-conns.
+func Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    FileFlag,
+			Usage:   "optional yaml config file, see config.example.yaml",
+			EnvVars: []string{"CONFIG_FILE"},
+		},
+	}
+	cfg := Default()
+	for _, s := range settings(reflect.ValueOf(&cfg).Elem(), "") {
+		usage := "overrides " + s.path + " of the config file"
+		if s.env != "" {
+			usage = "overrides $" + s.env
+		}
+		flags = append(flags, &cli.StringFlag{
+			Name:     flagName(s.path),
+			Usage:    usage,
+			Category: "config",
+		})
+	}
+	return flags
+}
+
+// Load builds the config from the defaults, the config file, the environment
+// and the flags returned by Flags, each overriding the former. Empty
+// environment variables count as unset. The config is validated.
+func Load(c *cli.Context) (*Config, error) {
+	cfg := Default()
+	if path := c.String(FileFlag); path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings(reflect.ValueOf(&cfg).Elem(), "") {
+		if raw := os.Getenv(s.env); raw != "" {
+			if err := set(s.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", s.env, err))
+			}
+		}
+		if name := flagName(s.path); c.IsSet(name) {
+			if err := set(s.value, c.String(name)); err != nil {
+				errs = append(errs, fmt.Errorf("invalid --%s: %w", name, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// Print writes the config in the format of the config file, with the
+// environment variable of each setting as comment. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node(reflect.ValueOf(c).Elem())); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	// Misspelled keys would otherwise be ignored silently.
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
+// settings walks the fields with a yaml key, descending into structs.
+func settings(v reflect.Value, prefix string) []setting {
+	var result []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := yamlKey(field)
+		if key == "" {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			result = append(result, settings(v.Field(i), prefix+key+".")...)
+			continue
+		}
+		result = append(result, setting{
+			path:  prefix + key,
+			env:   field.Tag.Get("env"),
+			value: v.Field(i),
+		})
+	}
+	return result
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+func flagName(path string) string {
+	return strings.ReplaceAll(path, "_", "-")
+}
+
+// set parses raw into the setting. Lists are comma separated, empty items
+// are dropped.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// node converts a section of the config into a yaml mapping, so that
+// durations are printed like they are written and secrets can be redacted.
+func node(v reflect.Value) *yaml.Node {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := yamlKey(field)
+		if key == "" {
+			continue
+		}
+
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+		var value *yaml.Node
+		if field.Type.Kind() == reflect.Struct {
+			value = node(v.Field(i))
+		} else {
+			value = &yaml.Node{}
+			var printed any = v.Field(i).Interface()
+			switch {
+			case field.Tag.Get("secret") == "true":
+				printed = redact(v.Field(i).String())
+			case field.Type == reflect.TypeOf(time.Duration(0)):
+				printed = v.Field(i).Interface().(time.Duration).String()
+			}
+			// Strings, numbers and lists always encode.
+			_ = value.Encode(printed)
+			// Comments of keys would end up after the items of empty lists.
+			if value.Kind == yaml.SequenceNode && len(value.Content) == 0 {
+				value.LineComment = field.Tag.Get("env")
+			} else {
+				keyNode.LineComment = field.Tag.Get("env")
+			}
+		}
+		mapping.Content = append(mapping.Content, keyNode, value)
+	}
+	return mapping
+}
+
+// redact hides a secret. Of URLs only the password is hidden, so that the
+// host of e.g. DATABASE_URL can still be checked.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if u, err := url.Parse(secret); err == nil && u.Host != "" {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return u.Redacted()
+		}
+	}
+	return redacted
+}
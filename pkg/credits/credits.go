@@ -0,0 +1,68 @@
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Reasons recorded on credit transactions.
+const (
+	ReasonOpeningBalance  = "opening_balance"
+	ReasonPoints          = "points"
+	ReasonPayoutReserve   = "payout_reserve"
+	ReasonPayoutRefund    = "payout_refund"
+	ReasonAdminAdjustment = "admin_adjustment"
+)
+
+// Transaction is an append-only change of a user's credit balance.
+// users.credits always equals the sum of the user's transactions.
+type Transaction struct {
+	ID             string         `db:"id" json:"id"`
+	UserID         string         `db:"user_id" json:"-"`
+	Amount         int            `db:"amount" json:"amount"`
+	Reason         string         `db:"reason" json:"reason"`
+	SourceJobRun   sql.NullString `db:"source_job_run" json:"-"`
+	IdempotencyKey string         `db:"idempotency_key" json:"-"`
+	CreatedAt      time.Time      `db:"created_at" json:"created_at"`
+}
+
+// PointsIdempotencyKey is the key of the points credited to a user for a
+// scoring window, whether they are paid by the points job or released later.
+func PointsIdempotencyKey(userID string, from, to time.Time) string {
+	return fmt.Sprintf("points:%s:%d:%d", userID, from.Unix(), to.Unix())
+}
+
+// Apply records the transaction and updates the user's balance within tx.
+// A transaction whose idempotency key has already been recorded is ignored
+// and reported as not applied.
+func Apply(ctx context.Context, tx *sqlx.Tx, t *Transaction) (bool, error) {
+	query, args, err := tx.BindNamed(`
+		INSERT INTO credit_transactions (user_id, amount, reason, source_job_run, idempotency_key)
+		VALUES (:user_id, :amount, :reason, :source_job_run, :idempotency_key)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id, created_at;
+	`, t)
+	if err != nil {
+		return false, err
+	}
+	err = tx.QueryRowxContext(ctx, query, args...).Scan(&t.ID, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET credits = credits + $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2;
+	`, t.Amount, t.UserID)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
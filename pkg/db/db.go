@@ -1,54 +1,44 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"log/slog"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // PostgreSQL driver
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
-	ConnectionString string
-	MaxOpenConns     int
-	MaxIdleConns     int
-	ConnMaxLifetime  time.Duration
-	ConnMaxIdleTime  time.Duration
+	ConnectionString string        `yaml:"url" env:"DATABASE_URL" secret:"true"`
+	MaxOpenConns     int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns     int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime  time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime  time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
 	// Retry configuration
-	MaxRetries    int
-	RetryDelay    time.Duration
-	RetryMaxDelay time.Duration
+	MaxRetries    int           `yaml:"max_retries" env:"DB_MAX_RETRIES"`
+	RetryDelay    time.Duration `yaml:"retry_delay" env:"DB_RETRY_DELAY"`
+	RetryMaxDelay time.Duration `yaml:"retry_max_delay" env:"DB_RETRY_MAX_DELAY"`
 }
 
-// DefaultConfig returns a default database configuration based on environment variables
+// DefaultConfig returns the default pool and retry settings, without a
+// connection string.
 func DefaultConfig() Config {
-	connString := getEnv("DATABASE_URL", "")
-
-	// Parse connection settings
-	maxOpenConns := getIntEnv("DB_MAX_OPEN_CONNS", 10)
-	maxIdleConns := getIntEnv("DB_MAX_IDLE_CONNS", 5)
-	connMaxLifetime := getDurationEnv("DB_CONN_MAX_LIFETIME", time.Hour)
-	connMaxIdleTime := getDurationEnv("DB_CONN_MAX_IDLE_TIME", 15*time.Minute)
-
-	// Get retry configuration
-	maxRetries := getIntEnv("DB_MAX_RETRIES", 5)
-	retryDelay := getDurationEnv("DB_RETRY_DELAY", 1*time.Second)
-	retryMaxDelay := getDurationEnv("DB_RETRY_MAX_DELAY", 30*time.Second)
-
 	return Config{
-		ConnectionString: connString,
-		MaxOpenConns:     maxOpenConns,
-		MaxIdleConns:     maxIdleConns,
-		ConnMaxLifetime:  connMaxLifetime,
-		ConnMaxIdleTime:  connMaxIdleTime,
-		MaxRetries:       maxRetries,
-		RetryDelay:       retryDelay,
-		RetryMaxDelay:    retryMaxDelay,
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: time.Hour,
+		ConnMaxIdleTime: 15 * time.Minute,
+		MaxRetries:      5,
+		RetryDelay:      1 * time.Second,
+		RetryMaxDelay:   30 * time.Second,
 	}
 }
 
@@ -83,7 +73,7 @@ func Connect(config Config) (*Database, error) {
 			}
 		}
 
-		db, err = sqlx.Connect("postgres", config.ConnectionString)
+		db, err = open(config.ConnectionString)
 		if err == nil {
 			break
 		}
@@ -143,13 +133,37 @@ func Connect(config Config) (*Database, error) {
 	}, nil
 }
 
+// open connects through otelsql, which records a span per query. Queries
+// outside of a trace, e.g. from pool maintenance, aren't recorded.
+func open(connectionString string) (*sqlx.DB, error) {
+	sqlDB, err := otelsql.Open("postgres", connectionString,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sqlDB, "postgres")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // Close closes the database connection
 func (d *Database) Close() error {
 	slog.Info("closing database connection")
 	return d.SQLX.Close()
 }
 
-// Helper functions for environment variables and connection
+// Helper functions
 
 // getHostFromConnString extracts the host from a connection string
 func getHostFromConnString(connString string) string {
@@ -162,40 +176,3 @@ func getHostFromConnString(connString string) string {
 	}
 	return "unknown"
 }
-
-// getEnv retrieves an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue
-	}
-	return value
-}
-
-// getIntEnv retrieves an environment variable as an integer or returns a default value
-func getIntEnv(key string, defaultValue int) int {
-	valueStr := getEnv(key, "")
-	if valueStr == "" {
-		return defaultValue
-	}
-
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
-	}
-	return value
-}
-
-// getDurationEnv retrieves an environment variable as a duration or returns a default value
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	valueStr := getEnv(key, "")
-	if valueStr == "" {
-		return defaultValue
-	}
-
-	value, err := time.ParseDuration(valueStr)
-	if err != nil {
-		return defaultValue
-	}
-	return value
-}
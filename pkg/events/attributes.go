@@ -0,0 +1,75 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Pub/Sub message attributes attached to every published client event so
+// consumers can attribute and filter messages without decoding the payload.
+const (
+	AttrUserID        = "user_id"
+	AttrSchemaVersion = "schema_version"
+	AttrEventType     = "event_type"
+	AttrReceivedAt    = "received_at"
+	AttrClientIPHash  = "client_ip_hash"
+	AttrRequestID     = "request_id"
+	// AttrConsentVersion is the consent version the user had agreed to when
+	// the event was received.
+	AttrConsentVersion = "consent_version"
+)
+
+// Metadata describes how and from whom the API received an event.
+type Metadata struct {
+	UserID       string
+	ReceivedAt   time.Time
+	ClientIPHash string
+	RequestID    string
+	// ConsentVersion is 0 if unknown.
+	ConsentVersion int
+}
+
+// Attributes builds the message attributes for the envelope.
+func (e *Envelope) Attributes(meta Metadata) map[string]string {
+	attrs := map[string]string{
+		AttrSchemaVersion: strconv.Itoa(e.SchemaVersion),
+		AttrEventType:     e.Data.Event,
+		AttrReceivedAt:    meta.ReceivedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if meta.UserID != "" {
+		attrs[AttrUserID] = meta.UserID
+	}
+	if meta.ClientIPHash != "" {
+		attrs[AttrClientIPHash] = meta.ClientIPHash
+	}
+	if meta.RequestID != "" {
+		attrs[AttrRequestID] = meta.RequestID
+	}
+	if meta.ConsentVersion > 0 {
+		attrs[AttrConsentVersion] = strconv.Itoa(meta.ConsentVersion)
+	}
+	return attrs
+}
+
+// ReceivedAt parses the received_at attribute.
+func ReceivedAt(attrs map[string]string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, attrs[AttrReceivedAt])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// HashClientIP pseudonymizes a client IP with a keyed hash so that events
+// from the same address can be correlated without storing the address.
+func HashClientIP(ip, salt string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Topic is the Pub/Sub topic client events are published to.
+const Topic = "client-events"
+
+// SchemaVersion is the current version of the client event schema. Payloads
+// without a schema_version are treated as version 1.
+const SchemaVersion = 1
+
+const (
+	TypeLoad             = "load"
+	TypeNavigation       = "navigation"
+	TypeExit             = "exit"
+	TypeVisibilityHidden = "visibility_hidden"
+)
+
+// Types lists every event type the extension emits.
+var Types = []string{TypeLoad, TypeNavigation, TypeExit, TypeVisibilityHidden}
+
+// Envelope is the body of POST /api/v1/event and the payload published to the
+// client-events topic.
+type Envelope struct {
+	SchemaVersion int       `json:"schema_version"`
+	Data          PageEvent `json:"data"`
+}
+
+// PageEvent mirrors the page info collected by the extension's content script.
+type PageEvent struct {
+	Event            string   `json:"event"`
+	Website          string   `json:"website"`
+	Path             string   `json:"path"`
+	Timestamp        string   `json:"timestamp"`
+	Title            string   `json:"title"`
+	URL              string   `json:"url"`
+	Referrer         string   `json:"referrer"`
+	Cookies          string   `json:"cookies,omitempty"`
+	Links            []string `json:"links,omitempty"`
+	Images           []Image  `json:"images,omitempty"`
+	Forms            []Form   `json:"forms,omitempty"`
+	TimeSpentSeconds int      `json:"timeSpentSeconds"`
+}
+
+type Image struct {
+	Src string `json:"src"`
+	Alt string `json:"alt"`
+}
+
+type Form struct {
+	ID     string      `json:"id"`
+	Action string      `json:"action"`
+	Method string      `json:"method"`
+	Inputs []FormInput `json:"inputs,omitempty"`
+}
+
+type FormInput struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// OccurredAt parses the client-side timestamp of the event.
+func (e *PageEvent) OccurredAt() (time.Time, error) {
+	return time.Parse(time.RFC3339, e.Timestamp)
+}
+
+// Unmarshal decodes an envelope without validating it, defaulting the schema
+// version of legacy payloads. Consumers use it to read published messages.
+func Unmarshal(data []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.SchemaVersion == 0 {
+		envelope.SchemaVersion = 1
+	}
+	return &envelope, nil
+}
+
+// Decode unmarshals and validates a client request body. Invalid input is
+// reported as a *ValidationError listing every offending field.
+func Decode(body []byte) (*Envelope, error) {
+	if len(body) > MaxEventBytes {
+		return nil, &ValidationError{Fields: []FieldError{{
+			Field:   "",
+			Message: fmt.Sprintf("event must not exceed %d bytes", MaxEventBytes),
+		}}}
+	}
+
+	envelope, err := Unmarshal(body)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{decodeFieldError(err)}}
+	}
+	if err := envelope.Validate(); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+func decodeFieldError(err error) FieldError {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		return FieldError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+		}
+	}
+	return FieldError{Message: "body must be a valid json object"}
+}
@@ -0,0 +1,168 @@
+package events
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Limits applied to client events. They are generous for real pages but keep
+// a single event from carrying megabytes of scraped markup.
+const (
+	MaxEventBytes       = 256 * 1024
+	MaxWebsiteLength    = 253
+	MaxPathLength       = 2048
+	MaxURLLength        = 2048
+	MaxTitleLength      = 1024
+	MaxCookiesLength    = 4096
+	MaxLinks            = 500
+	MaxImages           = 200
+	MaxForms            = 50
+	MaxFormInputs       = 100
+	MaxAttributeLength  = 1024
+	MaxInputValueBytes  = 1024
+	MaxTimeSpentSeconds = 24 * 60 * 60
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when an event doesn't match the schema.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if f.Field == "" {
+			msgs[i] = f.Message
+			continue
+		}
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid event: " + strings.Join(msgs, "; ")
+}
+
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) maxLength(field, value string, limit int) {
+	if len(value) > limit {
+		v.fail(field, "must not exceed %d characters", limit)
+	}
+}
+
+func (v *validator) url(field, value string, required bool) {
+	if value == "" {
+		if required {
+			v.fail(field, "is required")
+		}
+		return
+	}
+	if len(value) > MaxURLLength {
+		v.fail(field, "must not exceed %d characters", MaxURLLength)
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail(field, "must be an absolute http(s) url")
+	}
+}
+
+// Validate checks the envelope against the schema of its version.
+func (e *Envelope) Validate() error {
+	v := &validator{}
+
+	if e.SchemaVersion != SchemaVersion {
+		v.fail("schema_version", "unsupported version %d, expected %d", e.SchemaVersion, SchemaVersion)
+		return &ValidationError{Fields: v.fields}
+	}
+	e.Data.validate(v, "data")
+
+	if len(v.fields) > 0 {
+		return &ValidationError{Fields: v.fields}
+	}
+	return nil
+}
+
+func (e *PageEvent) validate(v *validator, prefix string) {
+	field := func(name string) string { return prefix + "." + name }
+
+	if e.Event == "" {
+		v.fail(field("event"), "is required")
+	} else if !slices.Contains(Types, e.Event) {
+		v.fail(field("event"), "must be one of %s", strings.Join(Types, ", "))
+	}
+
+	if e.Website == "" {
+		v.fail(field("website"), "is required")
+	} else {
+		v.maxLength(field("website"), e.Website, MaxWebsiteLength)
+	}
+	v.maxLength(field("path"), e.Path, MaxPathLength)
+	v.maxLength(field("title"), e.Title, MaxTitleLength)
+	v.maxLength(field("cookies"), e.Cookies, MaxCookiesLength)
+	v.url(field("url"), e.URL, true)
+	v.url(field("referrer"), e.Referrer, false)
+
+	if e.Timestamp == "" {
+		v.fail(field("timestamp"), "is required")
+	} else if _, err := time.Parse(time.RFC3339, e.Timestamp); err != nil {
+		v.fail(field("timestamp"), "must be an RFC 3339 timestamp")
+	}
+
+	if e.TimeSpentSeconds < 0 || e.TimeSpentSeconds > MaxTimeSpentSeconds {
+		v.fail(field("timeSpentSeconds"), "must be between 0 and %d", MaxTimeSpentSeconds)
+	}
+
+	if len(e.Links) > MaxLinks {
+		v.fail(field("links"), "must not contain more than %d items", MaxLinks)
+	} else {
+		for i, link := range e.Links {
+			v.maxLength(fmt.Sprintf("%s[%d]", field("links"), i), link, MaxURLLength)
+		}
+	}
+
+	if len(e.Images) > MaxImages {
+		v.fail(field("images"), "must not contain more than %d items", MaxImages)
+	} else {
+		for i, img := range e.Images {
+			p := fmt.Sprintf("%s[%d]", field("images"), i)
+			v.maxLength(p+".src", img.Src, MaxURLLength)
+			v.maxLength(p+".alt", img.Alt, MaxAttributeLength)
+		}
+	}
+
+	if len(e.Forms) > MaxForms {
+		v.fail(field("forms"), "must not contain more than %d items", MaxForms)
+		return
+	}
+	for i, form := range e.Forms {
+		p := fmt.Sprintf("%s[%d]", field("forms"), i)
+		v.maxLength(p+".id", form.ID, MaxAttributeLength)
+		v.maxLength(p+".action", form.Action, MaxURLLength)
+		v.maxLength(p+".method", form.Method, MaxAttributeLength)
+		if len(form.Inputs) > MaxFormInputs {
+			v.fail(p+".inputs", "must not contain more than %d items", MaxFormInputs)
+			continue
+		}
+		for j, input := range form.Inputs {
+			ip := fmt.Sprintf("%s.inputs[%d]", p, j)
+			v.maxLength(ip+".name", input.Name, MaxAttributeLength)
+			v.maxLength(ip+".type", input.Type, MaxAttributeLength)
+			if len(input.Value) > MaxInputValueBytes {
+				v.fail(ip+".value", "must not exceed %d bytes", MaxInputValueBytes)
+			}
+		}
+	}
+}
@@ -5,42 +5,97 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
 	"os"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
+	"github.com/devs-group/driplet/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/option"
 )
 
+var tracer = otel.Tracer("github.com/devs-group/driplet/pkg/pubsub")
+
 type Client struct {
 	*pubsub.Client
 	projectID string
+	config    Config
+
+	mu         sync.Mutex
+	publishers map[string]*Publisher
 }
 
 type Config struct {
-	ProjectID               string
-	CredentialsFile         string
-	EmulatorHost            string
-	AutoCreateTopics        bool
-	DefaultSubscriberConfig SubscriberConfig
+	ProjectID               string           `yaml:"project_id" env:"PUBSUB_PROJECT_ID"`
+	CredentialsFile         string           `yaml:"credentials_file" env:"PUBSUB_APPLICATION_CREDENTIALS"`
+	EmulatorHost            string           `yaml:"emulator_host" env:"PUBSUB_EMULATOR_HOST"`
+	AutoCreateTopics        bool             `yaml:"-"`
+	DefaultPublisherConfig  PublisherConfig  `yaml:"publish"`
+	DefaultSubscriberConfig SubscriberConfig `yaml:"-"`
+}
+
+// PublisherConfig controls how published messages are batched and how many
+// may be buffered before flow control kicks in.
+type PublisherConfig struct {
+	// A batch is sent once it holds CountThreshold messages or ByteThreshold
+	// bytes, or once its oldest message has waited DelayThreshold.
+	CountThreshold int           `yaml:"count_threshold" env:"PUBSUB_PUBLISH_COUNT_THRESHOLD"`
+	ByteThreshold  int           `yaml:"byte_threshold" env:"PUBSUB_PUBLISH_BYTE_THRESHOLD"`
+	DelayThreshold time.Duration `yaml:"delay_threshold" env:"PUBSUB_PUBLISH_DELAY_THRESHOLD"`
+	// MaxOutstandingMessages and MaxOutstandingBytes bound the messages
+	// buffered but not yet acknowledged by the server; zero disables a limit.
+	MaxOutstandingMessages int `yaml:"max_outstanding_messages" env:"PUBSUB_PUBLISH_MAX_OUTSTANDING_MESSAGES"`
+	MaxOutstandingBytes    int `yaml:"max_outstanding_bytes" env:"PUBSUB_PUBLISH_MAX_OUTSTANDING_BYTES"`
+	// BlockOnFlowControl makes PublishAsync block while the limits are
+	// exceeded instead of failing the publish.
+	BlockOnFlowControl bool `yaml:"-"`
 }
 
 type SubscriberConfig struct {
 	MaxOutstandingMessages int
 	NumGoroutines          int
-	AsyncPull              bool
+	// AsyncPull hands messages to a pool of Workers goroutines instead of
+	// processing them on the goroutines of the receive loop.
+	AsyncPull bool
+	Workers   int
+	// MinBackoff and MaxBackoff bound the redelivery delay of nacked messages.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Messages that failed MaxDeliveryAttempts times are moved to
+	// DeadLetterTopic. Without a dead-letter topic they are retried forever.
+	DeadLetterTopic     string
+	MaxDeliveryAttempts int
+	// LogAttributes are the message attributes added to the logger of every
+	// delivery, see Logger, e.g. to correlate messages with the request that
+	// published them.
+	LogAttributes []string
 }
 
 func DefaultConfig() Config {
 	return Config{
-		ProjectID:        getEnvOrDefault("PUBSUB_PROJECT_ID", "local-project"),
-		CredentialsFile:  getEnvOrDefault("PUBSUB_APPLICATION_CREDENTIALS", ""),
-		EmulatorHost:     os.Getenv("PUBSUB_EMULATOR_HOST"),
+		ProjectID:        "local-project",
 		AutoCreateTopics: true,
+		DefaultPublisherConfig: PublisherConfig{
+			CountThreshold:         100,
+			ByteThreshold:          1e6,
+			DelayThreshold:         10 * time.Millisecond,
+			MaxOutstandingMessages: 1000,
+			MaxOutstandingBytes:    100 * 1024 * 1024,
+			BlockOnFlowControl:     true,
+		},
 		DefaultSubscriberConfig: SubscriberConfig{
 			MaxOutstandingMessages: 10,
 			NumGoroutines:          1,
 			AsyncPull:              false,
+			Workers:                10,
+			MinBackoff:             10 * time.Second,
+			MaxBackoff:             10 * time.Minute,
+			MaxDeliveryAttempts:    5,
 		},
 	}
 }
@@ -68,27 +123,98 @@ func Connect(ctx context.Context, cfg Config) (*Client, error) {
 
 	slog.Info("pubsub connection has been established!")
 	return &Client{
-		Client:    client,
-		projectID: cfg.ProjectID,
+		Client:     client,
+		projectID:  cfg.ProjectID,
+		config:     cfg,
+		publishers: map[string]*Publisher{},
 	}, nil
 }
 
+// Publisher returns the shared publisher of the topic, creating it on first
+// use. Pooled publishers are flushed and stopped by Close.
+func (c *Client) Publisher(ctx context.Context, topicID string) (*Publisher, error) {
+	c.mu.Lock()
+	p, ok := c.publishers[topicID]
+	c.mu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	// The topic is set up without holding the lock, so that publishing to
+	// other topics doesn't wait for the network calls.
+	p, err := c.NewPublisher(ctx, topicID, c.config.AutoCreateTopics)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.publishers[topicID]; ok {
+		// A concurrent call has set up the topic first.
+		p.Close()
+		return existing, nil
+	}
+	c.publishers[topicID] = p
+	return p, nil
+}
+
+// Flush blocks until all messages buffered by pooled publishers are sent.
+func (c *Client) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.publishers {
+		p.Flush()
+	}
+}
+
+// Close flushes and stops all pooled publishers before closing the client.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	for topicID, p := range c.publishers {
+		p.Close()
+		delete(c.publishers, topicID)
+	}
+	c.mu.Unlock()
+
+	slog.Info("closing pubsub connection")
+	return c.Client.Close()
+}
+
+// CheckTopic verifies that the topic exists and can be accessed.
+func (c *Client) CheckTopic(ctx context.Context, topicID string) error {
+	exists, err := c.Topic(topicID).Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if topic exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("topic %s does not exist", topicID)
+	}
+	return nil
+}
+
 type Publisher struct {
 	topic *pubsub.Topic
-	mu    sync.Mutex
 }
 
-func (c *Client) NewPublisher(topicID string, autoCreate bool) (*Publisher, error) {
+// topicSetupTimeout bounds the calls that check for and create a topic.
+const topicSetupTimeout = 10 * time.Second
+
+// NewPublisher creates a publisher that isn't pooled; callers must Close it.
+func (c *Client) NewPublisher(ctx context.Context, topicID string, autoCreate bool) (*Publisher, error) {
 	topic := c.Topic(topicID)
 
 	if autoCreate {
-		exists, err := topic.Exists(context.Background())
+		ctx, cancel := context.WithTimeout(ctx, topicSetupTimeout)
+		defer cancel()
+
+		exists, err := topic.Exists(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check if topic exists: %w", err)
 		}
 
 		if !exists {
-			topic, err = c.CreateTopic(context.Background(), topicID)
+			topic, err = c.CreateTopic(ctx, topicID)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create topic: %w", err)
 			}
@@ -96,92 +222,89 @@ func (c *Client) NewPublisher(topicID string, autoCreate bool) (*Publisher, erro
 		}
 	}
 
+	cfg := c.config.DefaultPublisherConfig
+	topic.PublishSettings.CountThreshold = cfg.CountThreshold
+	topic.PublishSettings.ByteThreshold = cfg.ByteThreshold
+	topic.PublishSettings.DelayThreshold = cfg.DelayThreshold
+	topic.PublishSettings.FlowControlSettings = pubsub.FlowControlSettings{
+		MaxOutstandingMessages: cfg.MaxOutstandingMessages,
+		MaxOutstandingBytes:    cfg.MaxOutstandingBytes,
+		LimitExceededBehavior:  pubsub.FlowControlSignalError,
+	}
+	if cfg.BlockOnFlowControl {
+		topic.PublishSettings.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlBlock
+	}
+
 	return &Publisher{
 		topic: topic,
 	}, nil
 }
 
-func (p *Publisher) Publish(ctx context.Context, data []byte, attrs map[string]string) (serverID string, err error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// PublishResult is the future of a message handed to PublishAsync.
+type PublishResult struct {
+	result *pubsub.PublishResult
+}
 
-	msg := &pubsub.Message{
-		Data:       data,
-		Attributes: attrs,
-	}
+// Ready is closed once the outcome of the publish is known.
+func (r *PublishResult) Ready() <-chan struct{} {
+	return r.result.Ready()
+}
 
-	result := p.topic.Publish(ctx, msg)
-	serverID, err = result.Get(ctx)
+// Get blocks until the message has been published and returns its server id.
+func (r *PublishResult) Get(ctx context.Context) (serverID string, err error) {
+	serverID, err = r.result.Get(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to publish message: %w", err)
 	}
-
 	return serverID, nil
 }
 
-func (p *Publisher) Close() {
-	p.topic.Stop()
-}
-
-type Subscriber struct {
-	sub       *pubsub.Subscription
-	config    SubscriberConfig
-	projectID string
-}
-
-type MessageHandler func(context.Context, *pubsub.Message)
-
-func (c *Client) NewSubscriber(topicID, subscriptionID string, cfg SubscriberConfig, autoCreate bool) (*Subscriber, error) {
-	sub := c.Subscription(subscriptionID)
-
-	if autoCreate {
-		exists, err := sub.Exists(context.Background())
-		if err != nil {
-			return nil, fmt.Errorf("failed to check if subscription exists: %w", err)
-		}
+// PublishAsync queues the message for the next batch and returns without
+// waiting for the server. The trace context of ctx is added to the message
+// attributes, the span of the publish ends once its outcome is known.
+func (p *Publisher) PublishAsync(ctx context.Context, data []byte, attrs map[string]string) *PublishResult {
+	ctx, span := tracer.Start(ctx, p.topic.ID()+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemGCPPubsub,
+			semconv.MessagingDestinationName(p.topic.ID()),
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingMessageBodySize(len(data)),
+		),
+	)
+	msgAttrs := make(map[string]string, len(attrs)+2)
+	maps.Copy(msgAttrs, attrs)
+	tracing.Inject(ctx, msgAttrs)
 
-		if !exists {
-			topic := c.Topic(topicID)
-			sub, err = c.CreateSubscription(context.Background(), subscriptionID, pubsub.SubscriptionConfig{
-				Topic: topic,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create subscription: %w", err)
-			}
-			log.Printf("Created subscription: %s", subscriptionID)
-		}
+	msg := &pubsub.Message{
+		Data:       data,
+		Attributes: msgAttrs,
 	}
-
-	return &Subscriber{
-		sub:       sub,
-		config:    cfg,
-		projectID: c.projectID,
-	}, nil
+	result := p.topic.Publish(ctx, msg)
+	go func() {
+		<-result.Ready()
+		if serverID, err := result.Get(context.Background()); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(semconv.MessagingMessageID(serverID))
+		}
+		span.End()
+	}()
+	return &PublishResult{result: result}
 }
 
-func (s *Subscriber) Subscribe(ctx context.Context, handler MessageHandler) error {
-	// Configure subscription
-	s.sub.ReceiveSettings.MaxOutstandingMessages = s.config.MaxOutstandingMessages
-	s.sub.ReceiveSettings.NumGoroutines = s.config.NumGoroutines
-
-	if s.config.AsyncPull {
-		return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-			go func() {
-				handler(ctx, msg)
-				msg.Ack()
-			}()
-		})
-	}
+// Publish publishes the message and waits for its server id.
+func (p *Publisher) Publish(ctx context.Context, data []byte, attrs map[string]string) (serverID string, err error) {
+	return p.PublishAsync(ctx, data, attrs).Get(ctx)
+}
 
-	return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		handler(ctx, msg)
-		msg.Ack()
-	})
+// Flush blocks until all buffered messages have been sent.
+func (p *Publisher) Flush() {
+	p.topic.Flush()
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+// Close sends the remaining buffered messages and stops the publisher.
+func (p *Publisher) Close() {
+	p.topic.Stop()
 }
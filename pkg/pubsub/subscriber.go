@@ -0,0 +1,271 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/devs-group/driplet/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes added to messages moved to a dead-letter topic.
+const (
+	AttrDeadLetterError        = "dead_letter_error"
+	AttrDeadLetterSubscription = "dead_letter_subscription"
+	AttrDeadLetterAttempts     = "dead_letter_attempts"
+)
+
+type Subscriber struct {
+	sub        *pubsub.Subscription
+	config     SubscriberConfig
+	projectID  string
+	deadLetter *Publisher
+}
+
+// MessageHandler processes a message. Returning nil acks the message, an
+// error nacks it so that it is redelivered after the subscription's backoff.
+type MessageHandler func(context.Context, *pubsub.Message) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying, e.g. a malformed payload.
+// The message is moved to the dead-letter topic right away, or acked and
+// dropped if the subscriber has none.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func (c *Client) NewSubscriber(topicID, subscriptionID string, cfg SubscriberConfig, autoCreate bool) (*Subscriber, error) {
+	ctx := context.Background()
+	sub := c.Subscription(subscriptionID)
+
+	var deadLetter *Publisher
+	var deadLetterPolicy *pubsub.DeadLetterPolicy
+	if cfg.DeadLetterTopic != "" {
+		var err error
+		deadLetter, err = c.Publisher(ctx, cfg.DeadLetterTopic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter publisher: %w", err)
+		}
+		deadLetterPolicy = &pubsub.DeadLetterPolicy{
+			DeadLetterTopic:     fmt.Sprintf("projects/%s/topics/%s", c.projectID, cfg.DeadLetterTopic),
+			MaxDeliveryAttempts: cfg.MaxDeliveryAttempts,
+		}
+	}
+	retryPolicy := &pubsub.RetryPolicy{
+		MinimumBackoff: cfg.MinBackoff,
+		MaximumBackoff: cfg.MaxBackoff,
+	}
+
+	if autoCreate {
+		exists, err := sub.Exists(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if subscription exists: %w", err)
+		}
+
+		if !exists {
+			topic := c.Topic(topicID)
+			sub, err = c.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{
+				Topic:            topic,
+				RetryPolicy:      retryPolicy,
+				DeadLetterPolicy: deadLetterPolicy,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create subscription: %w", err)
+			}
+			log.Printf("Created subscription: %s", subscriptionID)
+		} else {
+			update := pubsub.SubscriptionConfigToUpdate{RetryPolicy: retryPolicy}
+			if deadLetterPolicy != nil {
+				update.DeadLetterPolicy = deadLetterPolicy
+			}
+			if _, err := sub.Update(ctx, update); err != nil {
+				// The emulator and restricted service accounts may reject
+				// updates; the client side policy below still applies.
+				slog.Warn("unable to update subscription policies", "subscription", subscriptionID, "err", err)
+			}
+		}
+	}
+
+	return &Subscriber{
+		sub:        sub,
+		config:     cfg,
+		projectID:  c.projectID,
+		deadLetter: deadLetter,
+	}, nil
+}
+
+// Subscribe receives messages until ctx is cancelled. Failed messages are
+// nacked, and once they reach MaxDeliveryAttempts (or fail permanently) they
+// are moved to the dead-letter topic.
+func (s *Subscriber) Subscribe(ctx context.Context, handler MessageHandler) error {
+	// Configure subscription
+	s.sub.ReceiveSettings.MaxOutstandingMessages = s.config.MaxOutstandingMessages
+	s.sub.ReceiveSettings.NumGoroutines = s.config.NumGoroutines
+
+	if !s.config.AsyncPull {
+		return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+			s.process(ctx, handler, msg)
+		})
+	}
+
+	workers := max(s.config.Workers, 1)
+	jobs := make(chan *pubsub.Message)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				s.process(ctx, handler, msg)
+			}
+		}()
+	}
+
+	err := s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		// Blocks while all workers are busy, which stops Receive from
+		// pulling more messages than the pool can handle.
+		select {
+		case jobs <- msg:
+		case <-ctx.Done():
+			msg.Nack()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	return err
+}
+
+type loggerKey struct{}
+
+// Logger returns the logger of the message being processed, which carries
+// its id and the configured LogAttributes. Outside of a handler it returns
+// the default logger.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// process runs the handler in a span that continues the trace of the
+// publisher.
+func (s *Subscriber) process(ctx context.Context, handler MessageHandler, msg *pubsub.Message) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, msg.Attributes), s.sub.ID()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemGCPPubsub,
+			semconv.MessagingDestinationName(s.sub.ID()),
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingMessageID(msg.ID),
+			semconv.MessagingMessageBodySize(len(msg.Data)),
+		),
+	)
+	defer span.End()
+
+	attempt := 0
+	if msg.DeliveryAttempt != nil {
+		attempt = *msg.DeliveryAttempt
+	}
+	logger := slog.With("message_id", msg.ID)
+	for _, attr := range s.config.LogAttributes {
+		if value, ok := msg.Attributes[attr]; ok {
+			logger = logger.With(attr, value)
+		}
+	}
+	logger.Debug("processing message", "attempt", attempt)
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+
+	err := handler(ctx, msg)
+	if err == nil {
+		msg.Ack()
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	switch decide(err, attempt, s.config.MaxDeliveryAttempts, s.deadLetter != nil) {
+	case outcomeRetry:
+		logger.Warn("unable to process message, it will be redelivered", "attempt", attempt, "err", err)
+		msg.Nack()
+	case outcomeDrop:
+		logger.Error("dropping message that can't be processed", "err", err)
+		msg.Ack()
+	case outcomeDeadLetter:
+		if deadLetterErr := s.moveToDeadLetter(ctx, msg, err, attempt); deadLetterErr != nil {
+			logger.Error("unable to move message to dead-letter topic", "err", deadLetterErr)
+			msg.Nack()
+			return
+		}
+		logger.Error("message has been moved to dead-letter topic", "attempt", attempt, "err", err)
+		msg.Ack()
+	case outcomeAck:
+		msg.Ack()
+	}
+}
+
+// outcome is what happens to a delivered message.
+type outcome int
+
+const (
+	outcomeAck outcome = iota
+	// outcomeRetry nacks the message, so that it is redelivered.
+	outcomeRetry
+	// outcomeDrop acks a message that failed for good.
+	outcomeDrop
+	// outcomeDeadLetter moves the message to the dead-letter topic.
+	outcomeDeadLetter
+)
+
+// decide picks the outcome of the attempt to process a message. Failed
+// messages are retried until they fail permanently or reach maxAttempts.
+// Without a dead-letter topic, permanent failures are dropped and exhausted
+// messages keep being retried, leaving them to the subscription's policy.
+func decide(err error, attempt, maxAttempts int, hasDeadLetter bool) outcome {
+	if err == nil {
+		return outcomeAck
+	}
+	var permanent *permanentError
+	isPermanent := errors.As(err, &permanent)
+	exhausted := maxAttempts > 0 && attempt >= maxAttempts
+
+	switch {
+	case !isPermanent && !exhausted:
+		return outcomeRetry
+	case hasDeadLetter:
+		return outcomeDeadLetter
+	case isPermanent:
+		return outcomeDrop
+	default:
+		return outcomeRetry
+	}
+}
+
+func (s *Subscriber) moveToDeadLetter(ctx context.Context, msg *pubsub.Message, cause error, attempt int) error {
+	attrs := make(map[string]string, len(msg.Attributes)+3)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+	attrs[AttrDeadLetterError] = cause.Error()
+	attrs[AttrDeadLetterSubscription] = s.sub.ID()
+	attrs[AttrDeadLetterAttempts] = strconv.Itoa(attempt)
+
+	_, err := s.deadLetter.Publish(ctx, msg.Data, attrs)
+	return err
+}
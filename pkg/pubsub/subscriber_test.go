@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	failed := errors.New("database is down")
+	malformed := Permanent(errors.New("malformed payload"))
+
+	tests := map[string]struct {
+		err           error
+		attempt       int
+		maxAttempts   int
+		hasDeadLetter bool
+		want          outcome
+	}{
+		"success":                              {nil, 1, 5, true, outcomeAck},
+		"first failure":                        {failed, 1, 5, true, outcomeRetry},
+		"failure before max":                   {failed, 4, 5, true, outcomeRetry},
+		"failure at max":                       {failed, 5, 5, true, outcomeDeadLetter},
+		"failure beyond max":                   {failed, 7, 5, true, outcomeDeadLetter},
+		"unknown attempt":                      {failed, 0, 5, true, outcomeRetry},
+		"no max attempts":                      {failed, 100, 0, true, outcomeRetry},
+		"at max without dead letter":           {failed, 5, 5, false, outcomeRetry},
+		"permanent":                            {malformed, 1, 5, true, outcomeDeadLetter},
+		"wrapped permanent":                    {fmt.Errorf("handler: %w", malformed), 1, 5, true, outcomeDeadLetter},
+		"permanent without max":                {malformed, 1, 0, true, outcomeDeadLetter},
+		"permanent without dead letter":        {malformed, 1, 5, false, outcomeDrop},
+		"permanent at max without dead letter": {malformed, 5, 5, false, outcomeDrop},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := decide(tt.err, tt.attempt, tt.maxAttempts, tt.hasDeadLetter); got != tt.want {
+				t.Errorf("expected outcome %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if Permanent(nil) != nil {
+		t.Error("expected no error for nil")
+	}
+	cause := errors.New("malformed payload")
+	err := Permanent(cause)
+	if !errors.Is(err, cause) {
+		t.Error("expected the permanent error to wrap its cause")
+	}
+	if err.Error() != cause.Error() {
+		t.Errorf("expected the message of the cause, got %q", err.Error())
+	}
+}
@@ -1,11 +1,184 @@
 package calculate_points
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/devs-group/driplet/pkg/credits"
+	"github.com/jmoiron/sqlx"
+)
+
+type Config struct {
+	// Events received within [From, To) are scored.
+	From   time.Time
+	To     time.Time
+	Model  Model
+	Source EventSource
+	DB     *sqlx.DB
+}
+
+// PreviousDay returns the window of the last complete UTC day.
+func PreviousDay(now time.Time) (time.Time, time.Time) {
+	to := now.UTC().Truncate(24 * time.Hour)
+	return to.AddDate(0, 0, -1), to
+}
+
+// Run scores the events of the window and credits the points to the users.
+// Users that already have a ledger row overlapping the window are skipped, so
+// re-running a window never pays out twice. It returns the number of scored
+// events.
+func Run(ctx context.Context, cfg Config) (int, error) {
+	if !cfg.From.Before(cfg.To) {
+		return 0, fmt.Errorf("invalid window: %s is not before %s", cfg.From, cfg.To)
+	}
+	startedAt := time.Now()
+	slog.Info("calculating points...", "from", cfg.From, "to", cfg.To)
+
+	events, err := cfg.Source.Events(ctx, cfg.From, cfg.To)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load events: %w", err)
+	}
+	scores := cfg.Model.Score(events)
+	assessments := cfg.Model.Fraud.Assess(events)
+
+	userIDs := make([]string, 0, len(scores))
+	for userID := range scores {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	tx, err := cfg.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serializes runs so that two of them can't both see a window as unscored.
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('calc-points'));"); err != nil {
+		return 0, fmt.Errorf("failed to acquire points lock: %w", err)
+	}
+
+	var runID string
+	err = tx.GetContext(ctx, &runID, `
+		INSERT INTO points_runs (window_start, window_end, started_at)
+		VALUES ($1, $2, $3)
+		RETURNING id;
+	`, cfg.From, cfg.To, startedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create points run: %w", err)
+	}
+
+	credited, total := 0, 0
+	withheldUsers, withheldPoints := 0, 0
+	for _, userID := range userIDs {
+		score, assessment := scores[userID], assessments[userID]
+		result, err := creditUser(ctx, tx, runID, cfg.From, cfg.To, score, assessment)
+		if err != nil {
+			return 0, fmt.Errorf("failed to credit user %s: %w", userID, err)
+		}
+		switch result {
+		case alreadyScored:
+			slog.Warn("window has already been scored for user, skipping", "user_id", userID)
+		case withheld:
+			slog.Warn("points have been withheld pending review",
+				"user_id", userID,
+				"points", score.Points,
+				"fraud_score", assessment.Score,
+				"signals", assessment.Signals)
+			withheldUsers++
+			withheldPoints += score.Points
+		case paid:
+			credited++
+			total += score.Points
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE points_runs
+		SET users = $1, points = $2, withheld_users = $3, withheld_points = $4, finished_at = NOW()
+		WHERE id = $5;
+	`, credited, total, withheldUsers, withheldPoints, runID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update points run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit points: %w", err)
+	}
+
+	slog.Info("points have been calculated",
+		"run_id", runID,
+		"events", len(events),
+		"users", credited,
+		"points", total,
+		"withheld_users", withheldUsers,
+		"withheld_points", withheldPoints,
+		"duration", time.Since(startedAt))
+	return len(events), nil
+}
+
+type creditResult int
+
+const (
+	paid creditResult = iota
+	withheld
+	alreadyScored
 )
 
-func Run() error {
-	// TODO: Calculate points for each user based on the data from BIG QUERY and write them to the postgres DB.
-	slog.Info("calculating points...")
-	return nil
+// creditUser records the user's ledger row for the window and credits the
+// points. The points of flagged users, and of users whose points are already
+// awaiting review, are withheld until an admin releases them.
+func creditUser(ctx context.Context, tx *sqlx.Tx, runID string, from, to time.Time, score *UserScore, assessment *FraudAssessment) (creditResult, error) {
+	hold := assessment.Flagged
+	if !hold {
+		err := tx.GetContext(ctx, &hold, `
+			SELECT EXISTS (SELECT 1 FROM points_ledger WHERE user_id = $1 AND review_status = 'pending');
+		`, score.UserID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	reviewStatus := sql.NullString{String: "pending", Valid: hold}
+	signals, err := json.Marshal(assessment.Signals)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO points_ledger (
+			run_id, user_id, window_start, window_end, points, events, unique_sites, time_spent_seconds, capped,
+			fraud_score, fraud_signals, review_status
+		)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+		WHERE NOT EXISTS (
+			SELECT 1 FROM points_ledger
+			WHERE user_id = $2 AND window_start < $4 AND window_end > $3
+		);
+	`, runID, score.UserID, from, to, score.Points, score.Events, score.UniqueSites, score.TimeSpentSeconds, score.Capped,
+		assessment.Score, string(signals), reviewStatus)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return alreadyScored, err
+	}
+
+	if hold {
+		return withheld, nil
+	}
+	if score.Points == 0 {
+		return paid, nil
+	}
+	_, err = credits.Apply(ctx, tx, &credits.Transaction{
+		UserID:         score.UserID,
+		Amount:         score.Points,
+		Reason:         credits.ReasonPoints,
+		SourceJobRun:   sql.NullString{String: runID, Valid: true},
+		IdempotencyKey: credits.PointsIdempotencyKey(score.UserID, from, to),
+	})
+	return paid, err
 }
@@ -0,0 +1,212 @@
+package calculate_points
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Signals of synthetic traffic the fraud rules look for.
+const (
+	// SignalEventRate is the most events a user sent within a minute.
+	SignalEventRate = "event_rate"
+	// SignalIdenticalPayloads is the most events sharing a single payload.
+	SignalIdenticalPayloads = "identical_payloads"
+	// SignalTimeSpent counts page views with an implausible time spent.
+	SignalTimeSpent = "time_spent"
+	// SignalClockSkew counts events whose timestamp is far from the time they
+	// were received.
+	SignalClockSkew = "clock_skew"
+	// SignalDomainVolume is the most events a user sent for a single website
+	// on a day.
+	SignalDomainVolume = "domain_volume"
+)
+
+// FraudRules configure the scoring of event streams. Every signal that
+// exceeds its threshold adds its weight to the user's fraud score, and the
+// points of users reaching FlagScore are withheld pending review. A zero
+// threshold disables its signal, a zero FlagScore disables withholding.
+type FraudRules struct {
+	FlagScore int            `yaml:"flag_score"`
+	Weights   map[string]int `yaml:"weights"`
+
+	MaxEventsPerMinute   int `yaml:"max_events_per_minute"`
+	MaxIdenticalPayloads int `yaml:"max_identical_payloads"`
+	// A page view may last at most MaxPlausibleTimeSpentSeconds. Totals per
+	// day aren't checked since tabs open in parallel legitimately add up to
+	// more than a day.
+	MaxPlausibleTimeSpentSeconds int `yaml:"max_plausible_time_spent_seconds"`
+	// MaxClockSkew is tolerated between the time an event occurred and the
+	// time it was received. Extensions queue events while offline, so up to
+	// MaxSkewedEvents skewed events are tolerated as well.
+	MaxClockSkew            time.Duration `yaml:"max_clock_skew"`
+	MaxSkewedEvents         int           `yaml:"max_skewed_events"`
+	MaxDailyEventsPerDomain int           `yaml:"max_daily_events_per_domain"`
+}
+
+func DefaultFraudRules() FraudRules {
+	return FraudRules{
+		FlagScore: 100,
+		Weights: map[string]int{
+			SignalEventRate:         100,
+			SignalIdenticalPayloads: 100,
+			SignalTimeSpent:         50,
+			SignalClockSkew:         50,
+			SignalDomainVolume:      100,
+		},
+		MaxEventsPerMinute:           60,
+		MaxIdenticalPayloads:         10,
+		MaxPlausibleTimeSpentSeconds: 12 * 60 * 60,
+		MaxClockSkew:                 6 * time.Hour,
+		MaxSkewedEvents:              10,
+		MaxDailyEventsPerDomain:      2000,
+	}
+}
+
+func (r FraudRules) validate() error {
+	if r.FlagScore < 0 || r.MaxEventsPerMinute < 0 || r.MaxIdenticalPayloads < 0 ||
+		r.MaxPlausibleTimeSpentSeconds < 0 || r.MaxClockSkew < 0 || r.MaxSkewedEvents < 0 ||
+		r.MaxDailyEventsPerDomain < 0 {
+		return fmt.Errorf("fraud rule values must not be negative")
+	}
+	for signal, weight := range r.Weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of fraud signal %s must not be negative", signal)
+		}
+	}
+	return nil
+}
+
+// FraudAssessment is the outcome of checking a user's events in a window.
+type FraudAssessment struct {
+	UserID string
+	Score  int
+	// Signals holds the observed value of every signal that exceeded its
+	// threshold.
+	Signals map[string]int
+	Flagged bool
+}
+
+// Assess checks the events of every user that has events.
+func (r FraudRules) Assess(events []Event) map[string]*FraudAssessment {
+	byUser := map[string][]Event{}
+	for _, e := range events {
+		byUser[e.UserID] = append(byUser[e.UserID], e)
+	}
+
+	assessments := make(map[string]*FraudAssessment, len(byUser))
+	for userID, userEvents := range byUser {
+		assessment := &FraudAssessment{UserID: userID, Signals: map[string]int{}}
+		signal := func(name string, value int, exceeded bool) {
+			if exceeded {
+				assessment.Signals[name] = value
+				assessment.Score += r.Weights[name]
+			}
+		}
+
+		if r.MaxEventsPerMinute > 0 {
+			n := maxEventsPerMinute(userEvents)
+			signal(SignalEventRate, n, n > r.MaxEventsPerMinute)
+		}
+		if r.MaxIdenticalPayloads > 0 {
+			n := maxIdenticalPayloads(userEvents)
+			signal(SignalIdenticalPayloads, n, n > r.MaxIdenticalPayloads)
+		}
+		if r.MaxPlausibleTimeSpentSeconds > 0 {
+			n := r.implausibleTimeSpent(userEvents)
+			signal(SignalTimeSpent, n, n > 0)
+		}
+		if r.MaxClockSkew > 0 {
+			n := r.skewedEvents(userEvents)
+			signal(SignalClockSkew, n, n > r.MaxSkewedEvents)
+		}
+		if r.MaxDailyEventsPerDomain > 0 {
+			n := maxDailyEventsPerDomain(userEvents)
+			signal(SignalDomainVolume, n, n > r.MaxDailyEventsPerDomain)
+		}
+
+		assessment.Flagged = r.FlagScore > 0 && assessment.Score >= r.FlagScore
+		assessments[userID] = assessment
+	}
+	return assessments
+}
+
+// maxEventsPerMinute slides a one minute window over the times the events
+// occurred. The receive times aren't used since batches arrive at once.
+func maxEventsPerMinute(events []Event) int {
+	times := make([]time.Time, 0, len(events))
+	for _, e := range events {
+		times = append(times, e.OccurredAt)
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+
+	most, start := 0, 0
+	for end := range times {
+		for times[end].Sub(times[start]) >= time.Minute {
+			start++
+		}
+		most = max(most, end-start+1)
+	}
+	return most
+}
+
+func maxIdenticalPayloads(events []Event) int {
+	counts := map[string]int{}
+	most := 0
+	for _, e := range events {
+		if e.PayloadHash == "" {
+			continue
+		}
+		counts[e.PayloadHash]++
+		most = max(most, counts[e.PayloadHash])
+	}
+	return most
+}
+
+// implausibleTimeSpent counts events with a negative time spent and page
+// views lasting longer than plausible. Every event of a page view reports the
+// time since the page loaded, so page views are judged by the most any of
+// their events reported.
+func (r FraudRules) implausibleTimeSpent(events []Event) int {
+	implausible := 0
+	views := pageViews{}
+	for _, e := range events {
+		if e.TimeSpentSeconds < 0 {
+			implausible++
+			continue
+		}
+		views.add(e)
+	}
+	for _, userViews := range views {
+		for _, view := range userViews {
+			if view.timeSpent > r.MaxPlausibleTimeSpentSeconds {
+				implausible++
+			}
+		}
+	}
+	return implausible
+}
+
+func (r FraudRules) skewedEvents(events []Event) int {
+	skewed := 0
+	for _, e := range events {
+		if e.ReceivedAt.Sub(e.OccurredAt).Abs() > r.MaxClockSkew {
+			skewed++
+		}
+	}
+	return skewed
+}
+
+func maxDailyEventsPerDomain(events []Event) int {
+	counts := map[string]int{}
+	most := 0
+	for _, e := range events {
+		if e.Website == "" {
+			continue
+		}
+		key := e.ReceivedAt.UTC().Format(time.DateOnly) + " " + e.Website
+		counts[key]++
+		most = max(most, counts[key])
+	}
+	return most
+}
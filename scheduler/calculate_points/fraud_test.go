@@ -0,0 +1,41 @@
+package calculate_points
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImplausibleTimeSpentJudgesPageViews(t *testing.T) {
+	rules := DefaultFraudRules()
+	loadedAt := time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC)
+	event := func(url string, timeSpent time.Duration) Event {
+		at := loadedAt.Add(timeSpent)
+		return Event{
+			UserID:           "alice",
+			Type:             "visibility_hidden",
+			Website:          "example.com",
+			URL:              url,
+			TimeSpentSeconds: int(timeSpent.Seconds()),
+			OccurredAt:       at,
+			ReceivedAt:       at,
+		}
+	}
+
+	// Switching tabs every minute for ten hours reports the growing time of
+	// the same page views over and over.
+	events := []Event{}
+	for i := range 600 {
+		events = append(events,
+			event("https://example.com/a", time.Duration(i)*time.Minute),
+			event("https://example.com/b", time.Duration(i)*time.Minute),
+		)
+	}
+	if n := rules.implausibleTimeSpent(events); n != 0 {
+		t.Errorf("expected no implausible page views, got %d", n)
+	}
+
+	events = append(events, event("https://example.com/c", 20*time.Hour))
+	if n := rules.implausibleTimeSpent(events); n != 1 {
+		t.Errorf("expected one implausible page view, got %d", n)
+	}
+}
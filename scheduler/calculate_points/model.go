@@ -0,0 +1,187 @@
+package calculate_points
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Model configures how events are turned into points.
+type Model struct {
+	// EventPoints are awarded per event of the given type.
+	EventPoints map[string]int `yaml:"event_points"`
+	// SecondsPerPoint awards one point per this many seconds spent on pages,
+	// counting at most MaxTimeSpentSeconds per page view.
+	SecondsPerPoint     int `yaml:"seconds_per_point"`
+	MaxTimeSpentSeconds int `yaml:"max_time_spent_seconds"`
+	// UniqueSitePoints are awarded per distinct website visited in a day.
+	UniqueSitePoints int `yaml:"unique_site_points"`
+	// DailyCap limits the points a user can earn per UTC day, 0 disables it.
+	DailyCap int `yaml:"daily_cap"`
+	// Fraud configures which users' points are withheld pending review.
+	Fraud FraudRules `yaml:"fraud"`
+}
+
+func DefaultModel() Model {
+	return Model{
+		EventPoints: map[string]int{
+			"load":       1,
+			"navigation": 1,
+		},
+		SecondsPerPoint:     60,
+		MaxTimeSpentSeconds: 30 * 60,
+		UniqueSitePoints:    2,
+		DailyCap:            500,
+		Fraud:               DefaultFraudRules(),
+	}
+}
+
+// LoadModel reads a model from a YAML file. Fields missing from the file
+// keep their default values.
+func LoadModel(path string) (Model, error) {
+	model := DefaultModel()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return model, fmt.Errorf("failed to read scoring model: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &model); err != nil {
+		return model, fmt.Errorf("failed to parse scoring model: %w", err)
+	}
+	if model.SecondsPerPoint < 0 || model.MaxTimeSpentSeconds < 0 || model.UniqueSitePoints < 0 || model.DailyCap < 0 {
+		return model, fmt.Errorf("scoring model values must not be negative")
+	}
+	if err := model.Fraud.validate(); err != nil {
+		return model, err
+	}
+	return model, nil
+}
+
+// UserScore is the outcome of scoring a user's events in a window.
+type UserScore struct {
+	UserID           string
+	Points           int
+	Events           int
+	UniqueSites      int
+	TimeSpentSeconds int
+	// Capped is set if the daily cap reduced the points on any day.
+	Capped bool
+}
+
+type dailyScore struct {
+	points    int
+	timeSpent int
+	sites     map[string]struct{}
+}
+
+// Score computes the points of every user that has events.
+func (m Model) Score(events []Event) map[string]*UserScore {
+	scores := map[string]*UserScore{}
+	days := map[string]map[string]*dailyScore{}
+	sites := map[string]map[string]struct{}{}
+	views := pageViews{}
+
+	for _, e := range sortedByOccurrence(events) {
+		score, ok := scores[e.UserID]
+		if !ok {
+			score = &UserScore{UserID: e.UserID}
+			scores[e.UserID] = score
+			days[e.UserID] = map[string]*dailyScore{}
+			sites[e.UserID] = map[string]struct{}{}
+		}
+
+		dayKey := e.ReceivedAt.UTC().Format(time.DateOnly)
+		day, ok := days[e.UserID][dayKey]
+		if !ok {
+			day = &dailyScore{sites: map[string]struct{}{}}
+			days[e.UserID][dayKey] = day
+		}
+
+		// The extension reports the time spent since the page loaded, so only
+		// the part a page view hasn't reported before is counted.
+		view := views.add(e)
+		timeSpent := min(view.timeSpent, m.MaxTimeSpentSeconds) - min(view.counted, m.MaxTimeSpentSeconds)
+		view.counted = view.timeSpent
+		score.Events++
+		score.TimeSpentSeconds += timeSpent
+		day.timeSpent += timeSpent
+
+		day.points += m.EventPoints[e.Type]
+		if e.Website != "" {
+			if _, seen := day.sites[e.Website]; !seen {
+				day.sites[e.Website] = struct{}{}
+				day.points += m.UniqueSitePoints
+			}
+			sites[e.UserID][e.Website] = struct{}{}
+		}
+	}
+
+	for userID, score := range scores {
+		for _, day := range days[userID] {
+			points := day.points
+			if m.SecondsPerPoint > 0 {
+				points += day.timeSpent / m.SecondsPerPoint
+			}
+			if m.DailyCap > 0 && points > m.DailyCap {
+				points = m.DailyCap
+				score.Capped = true
+			}
+			score.Points += points
+		}
+		score.UniqueSites = len(sites[userID])
+	}
+	return scores
+}
+
+// pageViewTolerance absorbs the rounding of timeSpentSeconds when the load
+// time of a page view is derived from its events.
+const pageViewTolerance = 2 * time.Second
+
+// pageView is a single load of a page. Every event of a page view reports
+// the time spent since the load, so the page view's time spent is the most
+// any of them reported rather than their sum.
+type pageView struct {
+	loadedAt  time.Time
+	timeSpent int
+	// counted is the time spent that has already been scored.
+	counted int
+}
+
+// pageViews groups events by user and URL into the page views they were
+// sent from. Navigations only change the fragment of a URL, so fragments are
+// ignored.
+type pageViews map[string][]*pageView
+
+// add returns the page view the event belongs to, starting a new one if no
+// page view of the URL was loaded at the time the event implies.
+func (v pageViews) add(e Event) *pageView {
+	url, _, _ := strings.Cut(e.URL, "#")
+	if url == "" {
+		url = e.Website
+	}
+	key := e.UserID + " " + url
+	timeSpent := max(e.TimeSpentSeconds, 0)
+	loadedAt := e.OccurredAt.Add(-time.Duration(timeSpent) * time.Second)
+
+	for _, view := range v[key] {
+		if loadedAt.Sub(view.loadedAt).Abs() <= pageViewTolerance {
+			view.timeSpent = max(view.timeSpent, timeSpent)
+			return view
+		}
+	}
+	view := &pageView{loadedAt: loadedAt, timeSpent: timeSpent}
+	v[key] = append(v[key], view)
+	return view
+}
+
+func sortedByOccurrence(events []Event) []Event {
+	sorted := slices.Clone(events)
+	slices.SortStableFunc(sorted, func(a, b Event) int {
+		return cmp.Or(cmp.Compare(a.UserID, b.UserID), a.OccurredAt.Compare(b.OccurredAt))
+	})
+	return sorted
+}
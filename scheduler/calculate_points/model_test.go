@@ -0,0 +1,87 @@
+package calculate_points
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func scoreFile(t *testing.T, model Model) map[string]*UserScore {
+	t.Helper()
+	from := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	source := &FileSource{Path: "testdata/events.ndjson"}
+	events, err := source.Events(context.Background(), from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	return model.Score(events)
+}
+
+func TestScoreCountsTimeSpentOncePerPageView(t *testing.T) {
+	scores := scoreFile(t, DefaultModel())
+
+	if len(scores) != 2 {
+		t.Fatalf("expected scores for alice and bob only, got %d", len(scores))
+	}
+
+	alice := scores["alice"]
+	// Two page views of /a with 360s and 60s, and one of the news site.
+	if alice.TimeSpentSeconds != 420 {
+		t.Errorf("alice: expected 420 seconds, got %d", alice.TimeSpentSeconds)
+	}
+	if alice.Events != 8 {
+		t.Errorf("alice: expected 8 events, got %d", alice.Events)
+	}
+	if alice.UniqueSites != 2 {
+		t.Errorf("alice: expected 2 unique sites, got %d", alice.UniqueSites)
+	}
+	// 4 load and navigation events, 2 sites and 420s at 60s per point.
+	if alice.Points != 4+2*2+7 {
+		t.Errorf("alice: expected 15 points, got %d", alice.Points)
+	}
+
+	bob := scores["bob"]
+	// The page view is capped at MaxTimeSpentSeconds.
+	if bob.TimeSpentSeconds != 1800 {
+		t.Errorf("bob: expected 1800 seconds, got %d", bob.TimeSpentSeconds)
+	}
+	if bob.Points != 1+2+30 {
+		t.Errorf("bob: expected 33 points, got %d", bob.Points)
+	}
+}
+
+func TestScoreRepeatedVisibilityChangesDontEarnMore(t *testing.T) {
+	model := DefaultModel()
+	loadedAt := time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC)
+	events := []Event{}
+	for i := range 50 {
+		at := loadedAt.Add(time.Duration(i) * time.Minute)
+		events = append(events, Event{
+			UserID:           "alice",
+			Type:             "visibility_hidden",
+			Website:          "example.com",
+			URL:              "https://example.com/a",
+			TimeSpentSeconds: i * 60,
+			OccurredAt:       at,
+			ReceivedAt:       at,
+		})
+	}
+
+	score := model.Score(events)["alice"]
+	if score.TimeSpentSeconds != 1800 {
+		t.Errorf("expected 1800 seconds, got %d", score.TimeSpentSeconds)
+	}
+	if score.Points != 2+30 {
+		t.Errorf("expected 32 points, got %d", score.Points)
+	}
+}
+
+func TestScoreAppliesDailyCap(t *testing.T) {
+	model := DefaultModel()
+	model.DailyCap = 10
+
+	bob := scoreFile(t, model)["bob"]
+	if bob.Points != 10 || !bob.Capped {
+		t.Errorf("expected 10 capped points, got %d (capped %t)", bob.Points, bob.Capped)
+	}
+}
@@ -2,20 +2,62 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/devs-group/driplet/pkg/config"
+	"github.com/devs-group/driplet/pkg/db"
+	"github.com/devs-group/driplet/pkg/tracing"
 	"github.com/devs-group/driplet/scheduler/calculate_points"
+	"github.com/devs-group/driplet/scheduler/metrics"
+	"github.com/devs-group/driplet/scheduler/purge_users"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// tracesFlushTimeout bounds how long a job waits for its spans to be exported.
+const tracesFlushTimeout = 5 * time.Second
+
 func main() {
+	var cfg *config.Config
+	var shutdownTracing func(context.Context) error
 	app := &cli.App{
 		Name:  "scheduler",
 		Usage: "job scheduler for cloud run",
+		Before: func(c *cli.Context) (err error) {
+			if cfg, err = config.Load(c); err != nil {
+				return err
+			}
+			cfg.Tracing.ServiceName = "driplet-scheduler"
+			shutdownTracing, err = tracing.Setup(c.Context, cfg.Tracing)
+			return err
+		},
+		After: func(c *cli.Context) error {
+			if shutdownTracing == nil {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tracesFlushTimeout)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				slog.Error("unable to flush traces", "err", err)
+			}
+			return nil
+		},
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "pushgateway-url",
+				Usage:   "prometheus pushgateway the jobs push their metrics to",
+				EnvVars: []string{"PUSHGATEWAY_URL"},
+			},
+		}, config.Flags()...),
 		Commands: []*cli.Command{
 			{
 				Name:  "wait",
@@ -37,11 +79,118 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:  "config",
+				Usage: "configuration commands",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "print",
+						Usage: "print the effective configuration with secrets redacted",
+						Action: func(c *cli.Context) error {
+							return cfg.Print(os.Stdout)
+						},
+					},
+				},
+			},
 			{
 				Name:  "calc-points",
 				Usage: "calculate points for users",
+				Flags: []cli.Flag{
+					&cli.TimestampFlag{
+						Name:   "from",
+						Usage:  "start of the window, defaults to the start of the previous UTC day",
+						Layout: time.RFC3339,
+					},
+					&cli.TimestampFlag{
+						Name:   "to",
+						Usage:  "end of the window (exclusive), defaults to the start of the current UTC day",
+						Layout: time.RFC3339,
+					},
+					&cli.StringFlag{
+						Name:    "model",
+						Usage:   "path to a yaml scoring model",
+						EnvVars: []string{"POINTS_MODEL_FILE"},
+					},
+					&cli.StringFlag{
+						Name:  "events-file",
+						Usage: "read events from a ndjson file instead of postgres",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					from, to := calculate_points.PreviousDay(time.Now())
+					if t := c.Timestamp("from"); t != nil {
+						from = *t
+					}
+					if t := c.Timestamp("to"); t != nil {
+						to = *t
+					}
+
+					if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+						return err
+					}
+
+					model := calculate_points.DefaultModel()
+					if path := c.String("model"); path != "" {
+						var err error
+						if model, err = calculate_points.LoadModel(path); err != nil {
+							return err
+						}
+					}
+
+					database, err := db.Connect(cfg.Database)
+					if err != nil {
+						return fmt.Errorf("failed to connect to database: %w", err)
+					}
+					defer database.Close()
+
+					var source calculate_points.EventSource = &calculate_points.PostgresSource{DB: database.SQLX}
+					if path := c.String("events-file"); path != "" {
+						source = &calculate_points.FileSource{Path: path}
+					}
+
+					return runJob(c, "calc-points", func(ctx context.Context) (map[string]int, error) {
+						scored, err := calculate_points.Run(ctx, calculate_points.Config{
+							From:   from,
+							To:     to,
+							Model:  model,
+							Source: source,
+							DB:     database.SQLX,
+						})
+						return map[string]int{"events": scored}, err
+					})
+				},
+			},
+			{
+				Name:  "purge-users",
+				Usage: "purge users whose deletion grace period has passed",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "batch-size",
+						Usage: "number of events deleted per statement",
+						Value: 1000,
+					},
+				},
 				Action: func(c *cli.Context) error {
-					return calculate_points.Run()
+					if c.Int("batch-size") <= 0 {
+						return fmt.Errorf("batch size must be positive")
+					}
+					if err := validateConfig(cfg.ValidateDatabase()); err != nil {
+						return err
+					}
+
+					database, err := db.Connect(cfg.Database)
+					if err != nil {
+						return fmt.Errorf("failed to connect to database: %w", err)
+					}
+					defer database.Close()
+
+					return runJob(c, "purge-users", func(ctx context.Context) (map[string]int, error) {
+						users, events, err := purge_users.Run(ctx, purge_users.Config{
+							BatchSize: c.Int("batch-size"),
+							DB:        database.SQLX,
+						})
+						return map[string]int{"users": users, "events": int(events)}, err
+					})
 				},
 			},
 		},
@@ -52,3 +201,35 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// validateConfig fails a command if it lacks settings it needs.
+func validateConfig(errs ...error) error {
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return nil
+}
+
+// runJob runs the job in a root span and pushes its metrics if a pushgateway
+// is configured. The job returns the number of rows it processed by kind. A
+// failed push is logged but doesn't fail the job.
+func runJob(c *cli.Context, job string, run func(ctx context.Context) (map[string]int, error)) error {
+	ctx, span := otel.Tracer("github.com/devs-group/driplet/scheduler").Start(c.Context, job)
+	startedAt := time.Now()
+	rows, err := run(ctx)
+	for kind, n := range rows {
+		span.SetAttributes(attribute.Int("job.rows."+kind, n))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "")
+	}
+	span.End()
+
+	if url := c.String("pushgateway-url"); url != "" {
+		if pushErr := metrics.Push(c.Context, url, job, time.Since(startedAt), rows, err); pushErr != nil {
+			slog.Error("unable to push job metrics", "job", job, "err", pushErr)
+		}
+	}
+	return err
+}
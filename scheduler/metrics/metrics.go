@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "driplet_scheduler"
+
+// Push sends the metrics of a job run to a Prometheus Pushgateway, since jobs
+// exit before they could be scraped. The metrics are grouped by job. Rows are
+// counted by kind, e.g. users and events. A failed run doesn't replace the
+// last success timestamp of its group.
+func Push(ctx context.Context, url, job string, duration time.Duration, rows map[string]int, runErr error) error {
+	durationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Duration of the last run of the job.",
+	})
+	durationGauge.Set(duration.Seconds())
+	rowsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_rows_processed",
+		Help:      "Rows processed by the last run of the job, by kind of row.",
+	}, []string{"kind"})
+	for kind, n := range rows {
+		rowsGauge.WithLabelValues(kind).Set(float64(n))
+	}
+	pusher := push.New(url, namespace).
+		Grouping("job_name", job).
+		Collector(durationGauge).
+		Collector(rowsGauge)
+
+	lastRun := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Time the job last succeeded.",
+	})
+	if runErr != nil {
+		lastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "job_last_failure_timestamp_seconds",
+			Help:      "Time the job last failed.",
+		})
+	}
+	lastRun.SetToCurrentTime()
+	pusher.Collector(lastRun)
+
+	// Add only replaces the pushed metrics, so the other timestamp is kept.
+	if err := pusher.AddContext(ctx); err != nil {
+		return fmt.Errorf("failed to push job metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package purge_users
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Config struct {
+	// BatchSize is the number of events deleted per statement, so that large
+	// histories don't hold locks for long.
+	BatchSize int
+	DB        *sqlx.DB
+}
+
+// Run purges the users whose deletion grace period has passed. Events are
+// deleted in batches, the user row last, which cascades to the credit
+// transactions, points ledger, payouts and wallet challenges. Users with
+// payouts that are still being processed are skipped until those settle. It
+// returns the number of purged users and of deleted events.
+func Run(ctx context.Context, cfg Config) (users int, events int64, err error) {
+	startedAt := time.Now()
+	slog.Info("purging deleted users...")
+
+	userIDs := []string{}
+	err = cfg.DB.SelectContext(ctx, &userIDs, `
+		SELECT id FROM users u
+		WHERE purge_after <= NOW()
+		AND NOT EXISTS (
+			SELECT 1 FROM payouts p
+			WHERE p.user_id = u.id AND p.status IN ('pending', 'approved')
+		)
+		ORDER BY purge_after;
+	`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load users to purge: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		n, err := purgeUser(ctx, cfg, userID)
+		events += n
+		if err != nil {
+			return users, events, fmt.Errorf("failed to purge user %s: %w", userID, err)
+		}
+		users++
+		slog.Info("user has been purged", "user_id", userID, "events", n)
+	}
+
+	slog.Info("deleted users have been purged",
+		"users", users,
+		"events", events,
+		"duration", time.Since(startedAt))
+	return users, events, nil
+}
+
+func purgeUser(ctx context.Context, cfg Config, userID string) (int64, error) {
+	var total int64
+	for {
+		res, err := cfg.DB.ExecContext(ctx, `
+			DELETE FROM events
+			WHERE id IN (SELECT id FROM events WHERE user_id = $1 LIMIT $2);
+		`, userID, cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(cfg.BatchSize) {
+			break
+		}
+	}
+
+	// Deletions can't be cancelled once purge_after has passed, the condition
+	// only guards against rows that changed since they were selected.
+	_, err := cfg.DB.ExecContext(ctx, "DELETE FROM users WHERE id = $1 AND purge_after <= NOW();", userID)
+	return total, err
+}